@@ -1,8 +1,10 @@
 package main
 
 import (
-	"github.com/go-code/ml/ftrl"
-	ml "github.com/go-code/ml/utils"
+	"log"
+
+	"github.com/go-code/goFTRL/ftrl"
+	ml "github.com/go-code/goFTRL/utils"
 )
 
 const (
@@ -14,15 +16,21 @@ func avazuAppModel() {
 	trainFile := fileDir + "avazu-app.tr"
 	validFile := fileDir + "avazu-app.val"
 
-	Dtrain := ml.MakeAndLoadDataset(trainFile, -1, false)
-	Dvalid := ml.MakeAndLoadDataset(validFile, -1, false)
+	Dtrain, err := ml.MakeAndLoadDataset(trainFile, -1, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Dvalid, err := ml.MakeAndLoadDataset(validFile, -1, false)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	params := ftrl.MakeParams(
 		0.1, 1.0, 0.5, 1.1,
 		1000, 0.0, 1e-4,
 		10, 'b')
 	logreg := ftrl.MakeFTRL(params)
-	logreg.Train(Dtrain, Dvalid)
+	logreg.Fit(Dtrain, Dvalid)
 	logreg.DecisionSummary()
 }
 
@@ -31,15 +39,21 @@ func avazuSiteModel() {
 	trainFile := fileDir + "avazu-site.tr"
 	validFile := fileDir + "avazu-site.val"
 
-	Dtrain := ml.MakeAndLoadDataset(trainFile, -1, false)
-	Dvalid := ml.MakeAndLoadDataset(validFile, -1, false)
+	Dtrain, err := ml.MakeAndLoadDataset(trainFile, -1, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Dvalid, err := ml.MakeAndLoadDataset(validFile, -1, false)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	params := ftrl.MakeParams(
 		0.1, 1.0, 0.5, 1.1,
 		1000, 0.0, 1e-4,
 		10, 'b')
 	logreg := ftrl.MakeFTRL(params)
-	logreg.Train(Dtrain, Dvalid)
+	logreg.Fit(Dtrain, Dvalid)
 	logreg.DecisionSummary()
 }
 
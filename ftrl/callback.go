@@ -0,0 +1,148 @@
+package ftrl
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CallbackStopOutputTemplate = "Training stopped by callback at #%02d"
+)
+
+// Callback is notified by Fit about progress of
+// training. Every method may stop training by
+// returning true. Methods are never called
+// concurrently, even in parallel training
+type Callback interface {
+	// OnEpochBegin is called before epoch is trained
+	OnEpochBegin(epoch uint64) bool
+	// OnEpochEnd is called after epoch is trained
+	// and validated
+	OnEpochEnd(epoch uint64, record *EpochRecord) bool
+	// OnSamples is called every n samples set by
+	// SetCallbacks, seen is number of samples
+	// of epoch processed so far
+	OnSamples(epoch, seen uint64) bool
+}
+
+// NopCallback implements Callback doing nothing,
+// embed it to implement only some of methods
+type NopCallback struct{}
+
+func (NopCallback) OnEpochBegin(epoch uint64) bool                    { return false }
+func (NopCallback) OnEpochEnd(epoch uint64, record *EpochRecord) bool { return false }
+func (NopCallback) OnSamples(epoch, seen uint64) bool                 { return false }
+
+// EpochRecord is summary of one epoch of Fit.
+// Validation values are NaN without validation
+// dataset
+type EpochRecord struct {
+	Epoch uint64
+	// TrainLoss is progressive loss of training samples
+	TrainLoss float64
+	// GradMean is mean gradient of loss w.r.t. margin
+	GradMean  float64
+	ValidLoss float64
+	MeanPred  float64
+	// Metrics are values of validation metrics
+	// set by SetMetrics by their names
+	Metrics map[string]float64
+	// Nnz is number of nonzero weights after epoch
+	Nnz      int
+	Samples  uint64
+	Duration time.Duration
+}
+
+// History is record of training by Fit
+type History struct {
+	Epochs []EpochRecord
+	// BestEpoch is epoch of final weights chosen by
+	// validation, zero without validation dataset
+	BestEpoch uint64
+	// Stopped is set if callback stopped training
+	Stopped  bool
+	Duration time.Duration
+}
+
+// SetCallbacks sets callbacks notified by Fit. OnSamples
+// is called every n samples, or never if n is zero. In
+// parallel training samples are counted by batches
+// or by chunks of every worker, so calls may be less
+// frequent
+func (a *FTRL) SetCallbacks(n uint64, callbacks ...Callback) {
+	a.every = n
+	a.callbacks = callbacks
+}
+
+// epochBegin notifies callbacks, returns true to stop
+func (a *FTRL) epochBegin(epoch uint64) bool {
+	stop := false
+	for _, cb := range a.callbacks {
+		stop = cb.OnEpochBegin(epoch) || stop
+	}
+	return stop
+}
+
+// epochEnd notifies callbacks, returns true to stop
+func (a *FTRL) epochEnd(epoch uint64, record *EpochRecord) bool {
+	stop := false
+	for _, cb := range a.callbacks {
+		stop = cb.OnEpochEnd(epoch, record) || stop
+	}
+	return stop
+}
+
+// progress counts samples of epoch for OnSamples
+// callbacks and watches for cancellation of context.
+// Nil progress counts nothing
+type progress struct {
+	callbacks []Callback
+	every     uint64
+	epoch     uint64
+	done      <-chan struct{}
+
+	seen    uint64
+	stopped int32
+	mu      sync.Mutex
+}
+
+// newProgress returns nil if no callback needs samples
+// to be counted and done channel is nil
+func newProgress(a *FTRL, epoch uint64, done <-chan struct{}) *progress {
+	every := a.every
+	if len(a.callbacks) == 0 {
+		every = 0
+	}
+	if every == 0 && done == nil {
+		return nil
+	}
+	return &progress{callbacks: a.callbacks, every: every, epoch: epoch, done: done}
+}
+
+// step counts n processed samples. Returns true if
+// training should stop or context is done. Safe for
+// concurrent use
+func (pr *progress) step(n uint64) bool {
+	if pr == nil {
+		return false
+	}
+	if pr.every > 0 {
+		seen := atomic.AddUint64(&pr.seen, n)
+		if seen/pr.every != (seen-n)/pr.every {
+			pr.mu.Lock()
+			for _, cb := range pr.callbacks {
+				if cb.OnSamples(pr.epoch, seen) {
+					atomic.StoreInt32(&pr.stopped, 1)
+				}
+			}
+			pr.mu.Unlock()
+		}
+	}
+	return atomic.LoadInt32(&pr.stopped) != 0 || canceled(pr.done)
+}
+
+// stop reports whether callback stopped training
+func (pr *progress) stop() bool {
+	return pr != nil && atomic.LoadInt32(&pr.stopped) != 0
+}
@@ -0,0 +1,89 @@
+package ftrl
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	CancelOutputTemplate     = "Training canceled at #%02d after %d samples: %v"
+	CheckpointOutputTemplate = "Checkpoint saved to %s"
+)
+
+// checkEveryRows is number of rows between checks
+// of context in prediction and validation
+const checkEveryRows = 1024
+
+// CanceledError is returned by FitContext when context
+// is done before training is finished. Model keeps
+// weights learned so far, including part of interrupted
+// epoch, so training may be continued later
+type CanceledError struct {
+	// Epoch is interrupted epoch, Samples is number
+	// of its samples learned before cancellation
+	Epoch   uint64
+	Samples uint64
+	// Checkpoint is path of saved model, empty if
+	// checkpoint is not set or could not be saved
+	Checkpoint string
+	// CheckpointErr is error of saving checkpoint
+	CheckpointErr error
+	// Err is error of context
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	msg := fmt.Sprintf("ftrl: training canceled at epoch %d after %d samples: %v",
+		e.Epoch, e.Samples, e.Err)
+	if e.CheckpointErr != nil {
+		msg += fmt.Sprintf(", checkpoint failed: %v", e.CheckpointErr)
+	}
+	return msg
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// SetCheckpoint makes FitContext save model to path
+// when training is canceled. Empty path disables it
+func (a *FTRL) SetCheckpoint(path string) {
+	a.checkpoint = path
+}
+
+// interrupt finishes history of canceled training,
+// saves checkpoint and returns CanceledError. Record
+// of interrupted epoch is added if it is not nil
+func (a *FTRL) interrupt(history *History, start time.Time,
+	epoch uint64, record *EpochRecord, err error) error {
+	cerr := &CanceledError{Epoch: epoch, Err: err}
+	if record != nil {
+		record.Nnz = a.nnz()
+		history.Epochs = append(history.Epochs, *record)
+		cerr.Samples = record.Samples
+	}
+	history.Duration = time.Since(start)
+	log.Printf(CancelOutputTemplate, epoch, cerr.Samples, err)
+
+	if a.checkpoint != "" {
+		if err := a.Save(a.checkpoint); err != nil {
+			cerr.CheckpointErr = err
+		} else {
+			cerr.Checkpoint = a.checkpoint
+			log.Printf(CheckpointOutputTemplate, a.checkpoint)
+		}
+	}
+	return cerr
+}
+
+// canceled reports whether done is closed. Nil
+// channel of background context is never closed
+func canceled(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
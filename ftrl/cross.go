@@ -0,0 +1,150 @@
+package ftrl
+
+import (
+	"fmt"
+	"sync"
+
+	util "github.com/go-code/goFTRL/utils"
+)
+
+// Cross is a conjunction of features from two or
+// three fields. Crossed feature is present when all
+// its parts are present, its value is product of
+// their values
+type Cross []int
+
+// AllPairs returns quadratic crosses of every pair
+// of given fields, including pairs of features
+// within the same field
+func AllPairs(fields ...int) []Cross {
+	crosses := make([]Cross, 0)
+	for i := range fields {
+		for j := i; j < len(fields); j++ {
+			crosses = append(crosses, Cross{fields[i], fields[j]})
+		}
+	}
+	return crosses
+}
+
+// crosser generates crossed features on the fly. Keys
+// of crossed features are hashed into 2^bits slots
+// starting from offset
+type crosser struct {
+	crosses []Cross
+	fields  *util.FieldMap
+	bits    uint
+	offset  uint64
+
+	pool    sync.Pool
+	samples sync.Pool
+}
+
+// SetCrosses makes model extend every sample with
+// crossed features, both in training and in prediction.
+// Fields of features are taken from fields map. Crossed
+// features occupy keys [offset, offset+2^bits), choose
+// offset above keys of raw features. Empty crosses
+// turn crossing off. Every cross must have 2 or 3 fields
+func (a *FTRL) SetCrosses(crosses []Cross, fields *util.FieldMap, bits uint, offset uint64) error {
+	for _, cross := range crosses {
+		if len(cross) < 2 || len(cross) > 3 {
+			return fmt.Errorf("ftrl: cross of %d fields", len(cross))
+		}
+	}
+	if len(crosses) == 0 {
+		a.crosses = nil
+		return nil
+	}
+	a.crosses = &crosser{
+		crosses: crosses,
+		fields:  fields,
+		bits:    bits,
+		offset:  offset,
+	}
+	return nil
+}
+
+// score returns prediction for x extended with
+// crossed features
+func (c *crosser) score(a *FTRL, x util.Sample) float64 {
+	buf, _ := c.samples.Get().(*util.Sample)
+	if buf == nil {
+		buf = new(util.Sample)
+	}
+	*buf = c.expand(x, (*buf)[:0])
+	p := a.score(*buf, false)
+	c.samples.Put(buf)
+	return p
+}
+
+// expand appends x and its crossed features to buf
+func (c *crosser) expand(x util.Sample, buf util.Sample) util.Sample {
+	buf = append(buf, x...)
+
+	// fields of features of x are kept in
+	// scratch buffer taken from pool
+	scratch, _ := c.pool.Get().(*[]int)
+	if scratch == nil {
+		scratch = new([]int)
+	}
+	fields := (*scratch)[:0]
+	for _, feature := range x {
+		fields = append(fields, c.fields.FieldOf(feature.Key))
+	}
+
+	mask := uint64(1)<<c.bits - 1
+	for _, cross := range c.crosses {
+		for i, fi := range fields {
+			if fi != cross[0] {
+				continue
+			}
+			for j, fj := range fields {
+				if fj != cross[1] || (cross[0] == cross[1] && j <= i) {
+					continue
+				}
+
+				value := x[i].Value * x[j].Value
+				if len(cross) == 2 {
+					key := crossKey(cross, [3]uint64{x[i].Key, x[j].Key})
+					buf = append(buf, util.Feature{Key: c.offset + key&mask, Value: value})
+					continue
+				}
+
+				for l, fl := range fields {
+					if fl != cross[2] || (cross[1] == cross[2] && l <= j) ||
+						(cross[0] == cross[2] && l <= i) {
+						continue
+					}
+					key3 := crossKey(cross, [3]uint64{x[i].Key, x[j].Key, x[l].Key})
+					buf = append(buf, util.Feature{Key: c.offset + key3&mask, Value: value * x[l].Value})
+				}
+			}
+		}
+	}
+
+	*scratch = fields
+	c.pool.Put(scratch)
+	return buf
+}
+
+// crossKey hashes keys of features of cross. Keys of
+// features of the same field are sorted first, so
+// crossed key does not depend on order of sample
+func crossKey(cross Cross, keys [3]uint64) uint64 {
+	for _, p := range [3][2]int{{0, 2}, {0, 1}, {1, 2}} {
+		i, j := p[0], p[1]
+		if j < len(cross) && cross[i] == cross[j] && keys[i] > keys[j] {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	key := crossHash(keys[0], keys[1])
+	if len(cross) == 3 {
+		key = crossHash(key, keys[2])
+	}
+	return key
+}
+
+// crossHash combines keys of crossed features
+func crossHash(a, b uint64) uint64 {
+	return mix64(mix64(a) ^ (b + 0x9e3779b97f4a7c15))
+}
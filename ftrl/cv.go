@@ -0,0 +1,126 @@
+package ftrl
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/go-code/goFTRL/metrics"
+	util "github.com/go-code/goFTRL/utils"
+)
+
+const (
+	CVFoldOutputTemplate = "Fold %d/%d. val.loss=%f auc=%f nnz=%d"
+)
+
+// cvInnerValid is share of training folds held out
+// for early stopping of fold model
+const cvInnerValid = 0.1
+
+// FoldResult is quality of model trained on one fold
+type FoldResult struct {
+	Loss float64
+	AUC  float64
+	Nnz  int
+}
+
+// CVResult is summary of cross-validation: mean and
+// standard deviation of fold results. Loss is loss of
+// model link, i.e. logloss for logistic regression
+type CVResult struct {
+	Folds []FoldResult
+
+	Loss, LossStd float64
+	AUC, AUCStd   float64
+	Nnz, NnzStd   float64
+}
+
+func (r CVResult) String() string {
+	return fmt.Sprintf("CV{folds:%d, loss:%f±%f, auc:%f±%f, nnz:%.1f±%.1f}",
+		len(r.Folds), r.Loss, r.LossStd, r.AUC, r.AUCStd, r.Nnz, r.NnzStd)
+}
+
+// CrossValidate trains k models with params, each on
+// k-1 folds of dataset, and evaluates them on the fold
+// left out. Folds are made with seed of params and are
+// stratified for logistic regression. Early stopping
+// of every model uses part of its training folds, so
+// held out fold is only scored. With parallel set
+// models are trained simultaneously.
+// Fails if dataset can not be split into k folds
+func CrossValidate(d *util.Dataset, k int, params Params, parallel bool) (CVResult, error) {
+	folds, err := d.KFold(k, params.seed, params.activation == 'b')
+	if err != nil {
+		return CVResult{}, err
+	}
+
+	results := make([]FoldResult, k)
+	var wg sync.WaitGroup
+	for i, fold := range folds {
+		run := func(i int, fold util.Fold) {
+			results[i] = evaluateFold(fold, params)
+			log.Printf(CVFoldOutputTemplate, i+1, k,
+				results[i].Loss, results[i].AUC, results[i].Nnz)
+		}
+		if !parallel {
+			run(i, fold)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, fold util.Fold) {
+			run(i, fold)
+			wg.Done()
+		}(i, fold)
+	}
+	wg.Wait()
+
+	losses := make([]float64, k)
+	aucs := make([]float64, k)
+	nnzs := make([]float64, k)
+	for i, r := range results {
+		losses[i], aucs[i], nnzs[i] = r.Loss, r.AUC, float64(r.Nnz)
+	}
+	return CVResult{
+		Folds:   results,
+		Loss:    util.Mean(losses),
+		LossStd: util.Std(losses),
+		AUC:     util.Mean(aucs),
+		AUCStd:  util.Std(aucs),
+		Nnz:     util.Mean(nnzs),
+		NnzStd:  util.Std(nnzs),
+	}, nil
+}
+
+func evaluateFold(fold util.Fold, params Params) FoldResult {
+	split := fold.Train.SplitRandom
+	if params.activation == 'b' {
+		split = fold.Train.SplitStratified
+	}
+	train, valid := fold.Train, (*util.Dataset)(nil)
+	if parts, err := split(params.seed, 1-cvInnerValid); err == nil && parts[1].NRows() > 0 {
+		train, valid = parts[0], parts[1]
+	}
+
+	model := MakeFTRL(params)
+	model.Fit(train, valid)
+	loss, auc := evaluate(model, fold.Valid)
+	return FoldResult{Loss: loss, AUC: auc, Nnz: model.nnz()}
+}
+
+// evaluate returns loss and AUC of model on dataset
+func evaluate(a *FTRL, d *util.Dataset) (float64, float64) {
+	loss, _ := a.Validate(d)
+	labels, weights := targets(d)
+	return loss, metrics.AUC(labels, a.PredictBatch(d), weights)
+}
+
+// nnz returns number of nonzero weights
+func (a *FTRL) nnz() int {
+	var count int
+	a.weights.each(func(k uint64, wptr *weights) {
+		if wptr.get(a.params) != 0 {
+			count++
+		}
+	})
+	return count
+}
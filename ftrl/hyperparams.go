@@ -9,6 +9,11 @@ type Params struct {
 	tol                           float64
 	niter                         uint64
 	activation                    rune
+	seed                          int64
+	patience                      uint64
+	nworkers                      int
+	deterministic                 bool
+	shuffle                       bool
 }
 
 func MakeParams(
@@ -27,6 +32,37 @@ func MakeParams(
 }
 
 func (p *Params) String() string {
-	return fmt.Sprintf("Hyperparams{Alpha:%v, Beta:%v, L1:%v, L2:%v, max_iter:%v, activation:%v}",
-		p.alpha, p.beta, p.lambda1, p.lambda2, p.niter, p.activation)
+	return fmt.Sprintf("Hyperparams{Alpha:%v, Beta:%v, L1:%v, L2:%v, dropout:%v, max_iter:%v, activation:%v}",
+		p.alpha, p.beta, p.lambda1, p.lambda2, p.dropout, p.niter, p.activation)
+}
+
+// SetSeed sets seed of random generator used in
+// training, e.g. for dropout masks
+func (p *Params) SetSeed(seed int64) {
+	p.seed = seed
+}
+
+// SetPatience sets number of epochs without validation
+// improvement larger than tol after which training
+// stops. Zero disables early stopping
+func (p *Params) SetPatience(n uint64) {
+	p.patience = n
+}
+
+// SetWorkers sets number of goroutines used for
+// training. With deterministic set workers only
+// compute predictions while updates are applied
+// in row order, so result does not depend on
+// scheduling. Otherwise workers update shared
+// weights without locks (Hogwild!)
+func (p *Params) SetWorkers(n int, deterministic bool) {
+	p.nworkers = n
+	p.deterministic = deterministic
+}
+
+// SetShuffle makes Fit visit training rows in new
+// random order every epoch. Order of epoch e is
+// given by seed+e, so training is reproducible
+func (p *Params) SetShuffle(shuffle bool) {
+	p.shuffle = shuffle
 }
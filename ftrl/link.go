@@ -0,0 +1,58 @@
+package ftrl
+
+import (
+	util "github.com/go-code/goFTRL/utils"
+)
+
+// LinkFunction is an alias for activation function signature
+type LinkFunction func(float64) float64
+
+// LossFunction is an alias for weighted loss signature
+// with arguments: prediction, target, sample weight
+type LossFunction func(float64, float64, float64) float64
+
+// linkByRune chooses activation function
+// by its short name
+func linkByRune(r rune) LinkFunction {
+	var f LinkFunction
+	if r == 'b' {
+		// exact sigmoid, so that scores match "logistic"
+		// link of exported model
+		f = util.Sigmoid
+	} else if r == 'g' {
+		f = util.Identity
+	} else if r == 'p' {
+		f = util.Exp
+	}
+	return f
+}
+
+// lossByRune chooses loss function matching
+// activation: logloss for logistic regression,
+// squared error for gaussian and deviance
+// for poisson regression
+func lossByRune(r rune) LossFunction {
+	var f LossFunction
+	if r == 'b' {
+		f = util.Logloss
+	} else if r == 'g' {
+		f = util.SquaredError
+	} else if r == 'p' {
+		f = util.PoissonDeviance
+	}
+	return f
+}
+
+// linkName returns human readable name of
+// activation function
+func linkName(r rune) string {
+	switch r {
+	case 'b':
+		return "logistic"
+	case 'g':
+		return "identity"
+	case 'p':
+		return "exp"
+	}
+	return "unknown"
+}
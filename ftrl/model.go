@@ -0,0 +1,521 @@
+package ftrl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	util "github.com/go-code/goFTRL/utils"
+)
+
+// Binary model layout (little endian):
+//
+//	magic      [4]byte "FTRL"
+//	version    uint16
+//	params     alpha, beta, l1, l2, clip, dropout, tol float64
+//	           niter uint64, activation int32
+//	training   seed int64, patience uint64, workers int32,
+//	           deterministic uint8, shuffle uint8
+//	store      kind uint8 (0 dense, 1 sharded, 2 hashed)
+//	           arg uint64 (table length, shards or hash bits)
+//	count      uint64 number of stored weights
+//	entries    count * {index uint64, ni float64, zi float64}
+//	crosses    ncross uint32, ncross * {n uint8, n * field int32}
+//	           if ncross > 0: bits uint8, offset uint64,
+//	           size uint64, size bytes of encoded field map
+//	checksum   uint32 crc32 (IEEE) of all preceding bytes
+//
+// Version 1 has no store kind, arg is length of dense table.
+// Versions 1 and 2 have no crosses, versions before 4 have
+// no training params, they are read as zero
+const modelVersion uint16 = 4
+
+// maxTableSize limits length of dense table, both grown
+// by training and read from file. Table read from file is
+// sized by the largest index of stored weights, not by
+// the header, so malformed file can not make Load
+// allocate unbounded memory
+const maxTableSize = 1 << 28
+
+// maxHashBits limits size of hashed table read from file,
+// 2^28 slots take 4 GB
+const maxHashBits = 28
+
+// maxShards limits number of shards read from file
+const maxShards = 1 << 16
+
+// modelEntrySize is encoded size of modelEntry
+const modelEntrySize = 24
+
+var modelMagic = [4]byte{'F', 'T', 'R', 'L'}
+
+var (
+	// ErrBadModel is returned when file is not a model
+	// or its content is malformed
+	ErrBadModel = errors.New("ftrl: malformed model file")
+	// ErrChecksum is returned when stored checksum
+	// does not match the content
+	ErrChecksum = errors.New("ftrl: model checksum mismatch")
+)
+
+type modelEntry struct {
+	Index  uint64
+	Ni, Zi float64
+}
+
+type paramsRecord struct {
+	Alpha, Beta, Lambda1, Lambda2 float64
+	Clipgrad, Dropout, Tol        float64
+	Niter                         uint64
+	Activation                    int32
+}
+
+type trainingRecord struct {
+	Seed          int64
+	Patience      uint64
+	Workers       int32
+	Deterministic bool
+	Shuffle       bool
+}
+
+// Save serializes model to file. Model is written to
+// temporary file first, which replaces path when it is
+// complete, so crash while saving keeps previous file
+func (a *FTRL) Save(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = a.writeModel(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load deserializes model from file
+func (a *FTRL) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return a.readModel(file, info.Size())
+}
+
+func (a *FTRL) writeModel(out io.Writer) error {
+	buf := bufio.NewWriter(out)
+	hash := crc32.NewIEEE()
+	w := io.MultiWriter(buf, hash)
+
+	var count uint64
+	a.weights.each(func(k uint64, wptr *weights) {
+		count++
+	})
+
+	p := a.params
+	kind, arg := a.weights.describe()
+	header := []interface{}{
+		modelMagic,
+		modelVersion,
+		paramsRecord{
+			p.alpha, p.beta, p.lambda1, p.lambda2,
+			p.clipgrad, p.dropout, p.tol,
+			p.niter, int32(p.activation)},
+		trainingRecord{
+			p.seed, p.patience, int32(p.nworkers),
+			p.deterministic, p.shuffle},
+		uint8(kind),
+		arg,
+		count,
+	}
+	for _, v := range header {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	a.weights.each(func(k uint64, wptr *weights) {
+		if err == nil {
+			e := modelEntry{k, wptr.ni, wptr.zi}
+			err = binary.Write(w, binary.LittleEndian, e)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeCrosses(w, a.crosses); err != nil {
+		return err
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, hash.Sum32()); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// readModel reads model of given size in bytes,
+// negative if size is unknown
+func (a *FTRL) readModel(in io.Reader, size int64) error {
+	buf := bufio.NewReader(in)
+	hash := crc32.NewIEEE()
+	counter := &countingReader{r: buf}
+	r := io.TeeReader(counter, hash)
+
+	var magic [4]byte
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadModel, err)
+	}
+	if magic != modelMagic {
+		return ErrBadModel
+	}
+
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadModel, err)
+	}
+	if version < 1 || version > modelVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadModel, version)
+	}
+
+	var rec paramsRecord
+	var training trainingRecord
+	var kind uint8
+	var arg, count uint64
+	fields := []interface{}{&rec, &training, &kind, &arg, &count}
+	switch {
+	case version == 1:
+		fields = []interface{}{&rec, &arg, &count}
+	case version < 4:
+		fields = []interface{}{&rec, &kind, &arg, &count}
+	}
+	for _, v := range fields {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadModel, err)
+		}
+	}
+
+	// upper bound of indexes, zero if unbounded
+	var tableSize uint64
+	switch storeKind(kind) {
+	case denseStore:
+		if arg > maxTableSize {
+			return fmt.Errorf("%w: dense table of size %d", ErrBadModel, arg)
+		}
+		tableSize = arg
+	case shardedStore:
+		if arg > maxShards {
+			return fmt.Errorf("%w: %d shards", ErrBadModel, arg)
+		}
+	case hashedStore:
+		if arg > maxHashBits {
+			return fmt.Errorf("%w: hashed table of %d bits", ErrBadModel, arg)
+		}
+		tableSize = 1 << arg
+	default:
+		return fmt.Errorf("%w: unknown store kind %d", ErrBadModel, kind)
+	}
+	if tableSize > 0 && count > tableSize {
+		return fmt.Errorf("%w: %d weights for table of size %d", ErrBadModel, count, tableSize)
+	}
+	if rest := size - counter.n; size >= 0 && (rest < 0 || count > uint64(rest)/modelEntrySize) {
+		return fmt.Errorf("%w: %d weights in %d bytes", ErrBadModel, count, rest)
+	}
+
+	var entries []modelEntry
+	var maxIndex uint64
+	var i uint64
+	for i = 0; i < count; i++ {
+		var e modelEntry
+		if err := binary.Read(r, binary.LittleEndian, &e); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadModel, err)
+		}
+		if tableSize > 0 && e.Index >= tableSize {
+			return fmt.Errorf("%w: weight index %d out of range", ErrBadModel, e.Index)
+		}
+		if e.Index > maxIndex {
+			maxIndex = e.Index
+		}
+		entries = append(entries, e)
+	}
+
+	store := makeStore(storeKind(kind), arg)
+	if len(entries) > 0 {
+		// dense table is only as long as its weights need
+		store.reserve(maxIndex+1, false)
+	}
+	for _, e := range entries {
+		store.assign(e.Index, weights{ni: e.Ni, zi: e.Zi})
+	}
+
+	var crosses *crosser
+	if version >= 3 {
+		var err error
+		if crosses, err = readCrosses(r); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadModel, err)
+		}
+	}
+
+	expected := hash.Sum32()
+	var stored uint32
+	if err := binary.Read(buf, binary.LittleEndian, &stored); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadModel, err)
+	}
+	if stored != expected {
+		return ErrChecksum
+	}
+
+	params := MakeParams(
+		rec.Alpha, rec.Beta, rec.Lambda1, rec.Lambda2,
+		rec.Clipgrad, rec.Dropout, rec.Tol,
+		rec.Niter, rune(rec.Activation))
+	params.SetSeed(training.Seed)
+	params.SetPatience(training.Patience)
+	params.SetWorkers(int(training.Workers), training.Deterministic)
+	params.SetShuffle(training.Shuffle)
+	a.SetParams(params)
+	a.activation = linkByRune(a.params.activation)
+	a.loss = lossByRune(a.params.activation)
+	a.weights = store
+	a.crosses = crosses
+	return nil
+}
+
+// countingReader counts bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func writeCrosses(w io.Writer, c *crosser) error {
+	if c == nil {
+		return binary.Write(w, binary.LittleEndian, uint32(0))
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(c.crosses))); err != nil {
+		return err
+	}
+	for _, cross := range c.crosses {
+		fields := make([]int32, len(cross))
+		for i, f := range cross {
+			fields[i] = int32(f)
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint8(len(fields))); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, fields); err != nil {
+			return err
+		}
+	}
+
+	fieldMap, err := c.fields.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for _, v := range []interface{}{uint8(c.bits), c.offset, uint64(len(fieldMap)), fieldMap} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readCrosses(r io.Reader) (*crosser, error) {
+	var ncross uint32
+	if err := binary.Read(r, binary.LittleEndian, &ncross); err != nil {
+		return nil, err
+	}
+	if ncross == 0 {
+		return nil, nil
+	}
+
+	crosses := make([]Cross, 0)
+	var i uint32
+	for i = 0; i < ncross; i++ {
+		var n uint8
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		if n < 2 || n > 3 {
+			return nil, fmt.Errorf("cross of %d fields", n)
+		}
+		fields := make([]int32, n)
+		if err := binary.Read(r, binary.LittleEndian, fields); err != nil {
+			return nil, err
+		}
+		cross := make(Cross, n)
+		for j, f := range fields {
+			cross[j] = int(f)
+		}
+		crosses = append(crosses, cross)
+	}
+
+	var bits uint8
+	var offset, size uint64
+	for _, v := range []interface{}{&bits, &offset, &size} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	data := make([]byte, 0)
+	buf := make([]byte, 4096)
+	for uint64(len(data)) < size {
+		chunk := buf
+		if rest := size - uint64(len(data)); rest < uint64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+	}
+
+	fieldMap := &util.FieldMap{}
+	if err := fieldMap.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &crosser{
+		crosses: crosses,
+		fields:  fieldMap,
+		bits:    uint(bits),
+		offset:  offset,
+	}, nil
+}
+
+// JSON export schema. Score of a sample is
+// link(sum(value * x[index])) over exported weights,
+// where link is one of "logistic", "identity", "exp".
+// Absent indexes have zero weight. For "hashed" store
+// index of feature k is splitmix64(k) mod 2^hash_bits,
+// see mix64. Crossed features of fields listed in
+// crosses are added to sample before scoring, see
+// crosser.expand. Field of raw feature is given by
+// cross_fields: field i owns keys [starts[i],
+// starts[i+1]), or table holds field of every key.
+const jsonFormatVersion = 1
+
+type jsonParams struct {
+	Alpha      float64 `json:"alpha"`
+	Beta       float64 `json:"beta"`
+	Lambda1    float64 `json:"lambda1"`
+	Lambda2    float64 `json:"lambda2"`
+	Clipgrad   float64 `json:"clipgrad"`
+	Dropout    float64 `json:"dropout"`
+	Tol        float64 `json:"tol"`
+	Niter      uint64  `json:"niter"`
+	Activation string  `json:"activation"`
+}
+
+type jsonFields struct {
+	Starts []uint64 `json:"starts,omitempty"`
+	Table  []int32  `json:"table,omitempty"`
+}
+
+type jsonWeight struct {
+	Index uint64  `json:"index"`
+	Name  string  `json:"name,omitempty"`
+	Value float64 `json:"value"`
+}
+
+type jsonModel struct {
+	Format      string       `json:"format"`
+	Version     int          `json:"version"`
+	Link        string       `json:"link"`
+	Params      jsonParams   `json:"params"`
+	NumFeatures uint64       `json:"num_features,omitempty"`
+	Store       string       `json:"store"`
+	HashBits    uint64       `json:"hash_bits,omitempty"`
+	Crosses     []Cross      `json:"crosses,omitempty"`
+	CrossFields *jsonFields  `json:"cross_fields,omitempty"`
+	CrossBits   uint         `json:"cross_bits,omitempty"`
+	CrossOffset uint64       `json:"cross_offset,omitempty"`
+	Weights     []jsonWeight `json:"weights"`
+}
+
+// ToJSON serializes nonzero model weights to
+// json format as input to any inference
+// engine. Names are attached to weights when
+// names slice covers their indexes, pass
+// Dataset.FeatureNames() or nil. Weights of
+// hashed store are slots and get no names
+func (a *FTRL) ToJSON(out io.Writer, names []string) error {
+	p := a.params
+	model := jsonModel{
+		Format:  "goftrl",
+		Version: jsonFormatVersion,
+		Link:    linkName(p.activation),
+		Params: jsonParams{
+			p.alpha, p.beta, p.lambda1, p.lambda2,
+			p.clipgrad, p.dropout, p.tol,
+			p.niter, string(p.activation)},
+		Weights: make([]jsonWeight, 0),
+	}
+
+	switch kind, arg := a.weights.describe(); kind {
+	case denseStore:
+		model.Store = "dense"
+		model.NumFeatures = arg
+	case shardedStore:
+		model.Store = "sharded"
+	case hashedStore:
+		model.Store = "hashed"
+		model.HashBits = arg
+	}
+
+	if a.crosses != nil {
+		model.Crosses = a.crosses.crosses
+		model.CrossFields = &jsonFields{
+			Starts: a.crosses.fields.Starts(),
+			Table:  a.crosses.fields.Table()}
+		model.CrossBits = a.crosses.bits
+		model.CrossOffset = a.crosses.offset
+	}
+
+	// slots of hashed store are not features,
+	// so they have no names
+	if model.Store == "hashed" {
+		names = nil
+	}
+	for k, v := range a.GetWeights() {
+		w := jsonWeight{Index: k, Value: v}
+		if k < uint64(len(names)) {
+			w.Name = names[k]
+		}
+		model.Weights = append(model.Weights, w)
+	}
+	sort.Slice(model.Weights, func(i, j int) bool {
+		return model.Weights[i].Index < model.Weights[j].Index
+	})
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(model)
+}
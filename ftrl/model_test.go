@@ -0,0 +1,1050 @@
+package ftrl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-code/goFTRL/metrics"
+	util "github.com/go-code/goFTRL/utils"
+)
+
+const toySVM = `1 0:1 3:1 5:1
+0 1:1 3:1 4:1
+1 0:1 2:1 5:1
+0 1:1 2:1 4:1
+1 0:1 4:1 5:1
+0 1:1 3:1 5:1
+1 0:1 2:1 3:1
+0 1:1 4:1 5:1
+`
+
+func loadSVM(t testing.TB, path string, isBinary bool) *util.Dataset {
+	d, err := util.MakeAndLoadDataset(path, -1, isBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func toyDataset(t testing.TB) *util.Dataset {
+	path := filepath.Join(t.TempDir(), "toy.svm")
+	if err := os.WriteFile(path, []byte(toySVM), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return loadSVM(t, path, true)
+}
+
+func toyModel(t *testing.T, d *util.Dataset) *FTRL {
+	params := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.0, 1e-4, 3, 'b')
+	model := MakeFTRL(params)
+	model.Fit(d, nil)
+	return model
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	d := toyDataset(t)
+	params := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.2, 1e-4, 3, 'b')
+	params.SetSeed(7)
+	params.SetPatience(4)
+	params.SetWorkers(2, true)
+	params.SetShuffle(true)
+	model := MakeFTRL(params)
+	model.Fit(d, nil)
+
+	path := filepath.Join(t.TempDir(), "model.bin")
+	if err := model.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := MakeFTRL(Params{})
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.GetParams() != model.GetParams() {
+		t.Errorf("params differ: %v vs %v", loaded.params, model.params)
+	}
+
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if loaded.Predict(x) != model.Predict(x) {
+			t.Errorf("row %d: predictions differ", i)
+		}
+	}
+
+	// both models must stay equal after more training
+	model.Fit(d, nil)
+	loaded.Fit(d, nil)
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if loaded.Predict(x) != model.Predict(x) {
+			t.Errorf("row %d: predictions differ after training", i)
+		}
+	}
+}
+
+func TestLoadDetectsCorruption(t *testing.T) {
+	model := toyModel(t, toyDataset(t))
+
+	var buf bytes.Buffer
+	if err := model.writeModel(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-10] ^= 0xff
+	err := MakeFTRL(Params{}).readModel(bytes.NewReader(corrupted), int64(len(corrupted)))
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	err = MakeFTRL(Params{}).readModel(bytes.NewReader(data[:len(data)/2]), -1)
+	if !errors.Is(err, ErrBadModel) {
+		t.Errorf("expected malformed model error, got %v", err)
+	}
+
+	err = MakeFTRL(Params{}).readModel(bytes.NewReader([]byte("junkjunk")), 8)
+	if !errors.Is(err, ErrBadModel) {
+		t.Errorf("expected malformed model error, got %v", err)
+	}
+
+	// sizes of header must be checked before allocation,
+	// kind and arg follow magic, version and params
+	offset := 4 + 2 + binary.Size(paramsRecord{}) + binary.Size(trainingRecord{})
+	for _, c := range []struct {
+		kind  storeKind
+		arg   uint64
+		count uint64
+	}{
+		{denseStore, 1 << 62, 0},
+		{hashedStore, 60, 0},
+		{shardedStore, 1 << 62, 0},
+		{denseStore, 1 << 20, 1000},
+	} {
+		header := make([]byte, offset+17)
+		copy(header, data[:offset])
+		header[offset] = byte(c.kind)
+		binary.LittleEndian.PutUint64(header[offset+1:], c.arg)
+		binary.LittleEndian.PutUint64(header[offset+9:], c.count)
+		err := MakeFTRL(Params{}).readModel(bytes.NewReader(header), int64(len(header)))
+		if !errors.Is(err, ErrBadModel) {
+			t.Errorf("kind %d, arg %d, count %d: expected malformed model error, got %v",
+				c.kind, c.arg, c.count, err)
+		}
+	}
+
+	// well formed files with huge tables pass checksum,
+	// table must be sized by stored weights
+	craft := func(kind storeKind, arg uint64, entries ...modelEntry) []byte {
+		var buf bytes.Buffer
+		buf.Write(data[:offset])
+		for _, v := range []interface{}{uint8(kind), arg, uint64(len(entries)), entries, uint32(0)} {
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+		binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+		return buf.Bytes()
+	}
+	for _, file := range [][]byte{
+		craft(denseStore, 1<<32),
+		craft(hashedStore, 32),
+		craft(denseStore, maxTableSize, modelEntry{Index: maxTableSize}),
+	} {
+		err := MakeFTRL(Params{}).readModel(bytes.NewReader(file), int64(len(file)))
+		if !errors.Is(err, ErrBadModel) {
+			t.Errorf("expected malformed model error, got %v", err)
+		}
+	}
+	file := craft(denseStore, maxTableSize, modelEntry{Index: 5, Ni: 1, Zi: 1})
+	loaded := MakeFTRL(Params{})
+	if err := loaded.readModel(bytes.NewReader(file), int64(len(file))); err != nil {
+		t.Fatal(err)
+	}
+	if _, size := loaded.weights.describe(); size != 6 {
+		t.Errorf("dense table of size %d, expected 6", size)
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	model := toyModel(t, toyDataset(t))
+	names := []string{"a", "b", "c"}
+
+	var buf bytes.Buffer
+	if err := model.ToJSON(&buf, names); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded jsonModel
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Link != "logistic" || decoded.Params.Activation != "b" {
+		t.Errorf("wrong link: %v %v", decoded.Link, decoded.Params.Activation)
+	}
+
+	expected := model.GetWeights()
+	if len(decoded.Weights) != len(expected) {
+		t.Fatalf("expected %d weights, got %d", len(expected), len(decoded.Weights))
+	}
+	for _, w := range decoded.Weights {
+		if expected[w.Index] != w.Value {
+			t.Errorf("weight %d: %v != %v", w.Index, w.Value, expected[w.Index])
+		}
+		if w.Index < uint64(len(names)) && w.Name != names[w.Index] {
+			t.Errorf("weight %d: wrong name %q", w.Index, w.Name)
+		}
+		if w.Index >= uint64(len(names)) && w.Name != "" {
+			t.Errorf("weight %d: unexpected name %q", w.Index, w.Name)
+		}
+	}
+
+	// consumers of JSON reproduce scores with exact sigmoid
+	d := toyDataset(t)
+	exported := make(map[uint64]float64)
+	for _, w := range decoded.Weights {
+		exported[w.Index] = w.Value
+	}
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		var margin float64
+		for _, f := range d.Row(i) {
+			margin += exported[f.Key] * f.Value
+		}
+		if p := 1 / (1 + math.Exp(-margin)); math.Abs(p-model.Predict(d.Row(i))) > 1e-12 {
+			t.Errorf("row %d: logistic score %v, model predicts %v", i, p, model.Predict(d.Row(i)))
+		}
+	}
+}
+
+func TestSetWeightsAndState(t *testing.T) {
+	d := toyDataset(t)
+	model := toyModel(t, d)
+
+	fromCoefs := MakeFTRL(model.GetParams())
+	fromCoefs.SetWeights(model.GetWeights())
+
+	fromState := MakeFTRL(model.GetParams())
+	fromState.SetState(model.GetState())
+
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		expected := model.Predict(x)
+		if p := fromCoefs.Predict(x); math.Abs(p-expected) > 1e-12 {
+			t.Errorf("row %d: SetWeights predicts %v, expected %v", i, p, expected)
+		}
+		if p := fromState.Predict(x); p != expected {
+			t.Errorf("row %d: SetState predicts %v, expected %v", i, p, expected)
+		}
+	}
+
+	// raw state continues training exactly like the original
+	model.Fit(d, nil)
+	fromState.Fit(d, nil)
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if fromState.Predict(x) != model.Predict(x) {
+			t.Errorf("row %d: predictions differ after training", i)
+		}
+	}
+}
+
+func TestDropoutIsReproducible(t *testing.T) {
+	d := toyDataset(t)
+	params := MakeParams(0.1, 1.0, 0.0, 0.1, 1000, 0.5, 1e-4, 5, 'b')
+	params.SetSeed(7)
+
+	first := MakeFTRL(params)
+	first.Fit(d, nil)
+	second := MakeFTRL(params)
+	second.Fit(d, nil)
+	plain := toyModel(t, d)
+
+	var i uint64
+	differs := false
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if first.Predict(x) != second.Predict(x) {
+			t.Errorf("row %d: same seed gives different models", i)
+		}
+		if first.Predict(x) != first.Predict(x) {
+			t.Errorf("row %d: prediction is not deterministic", i)
+		}
+		differs = differs || first.Predict(x) != plain.Predict(x)
+	}
+	if !differs {
+		t.Error("dropout has no effect on training")
+	}
+}
+
+func TestShuffleIsReproducible(t *testing.T) {
+	d := toyDataset(t)
+	params := MakeParams(0.1, 1.0, 0.0, 0.1, 1000, 0.0, 1e-4, 5, 'b')
+	params.SetSeed(7)
+	params.SetShuffle(true)
+
+	first := MakeFTRL(params)
+	first.Fit(d, nil)
+	second := MakeFTRL(params)
+	second.Fit(d, nil)
+
+	// single epoch visits rows in order of seed+1
+	params = MakeParams(0.1, 1.0, 0.0, 0.1, 1000, 0.0, 1e-4, 1, 'b')
+	params.SetSeed(7)
+	params.SetShuffle(true)
+	shuffled := MakeFTRL(params)
+	shuffled.Fit(d, nil)
+	params.SetShuffle(false)
+	manual := MakeFTRL(params)
+	manual.Fit(d.Shuffle(8), nil)
+
+	// states are compared, as they tell models
+	// apart better than rounded predictions
+	if !reflect.DeepEqual(first.GetState(), second.GetState()) {
+		t.Error("same seed gives different models")
+	}
+	if !reflect.DeepEqual(shuffled.GetState(), manual.GetState()) {
+		t.Error("epoch is not trained on shuffled rows")
+	}
+	params.SetShuffle(false)
+	plain := MakeFTRL(params)
+	plain.Fit(d, nil)
+	if reflect.DeepEqual(shuffled.GetState(), plain.GetState()) {
+		t.Error("shuffling has no effect on training")
+	}
+}
+
+func TestEarlyStoppingRestoresBestEpoch(t *testing.T) {
+	d := toyDataset(t)
+
+	// labels are flipped, so validation loss grows
+	// after the first epoch
+	flipped := ""
+	for _, line := range bytes.Split([]byte(toySVM), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		label := "1"
+		if line[0] == '1' {
+			label = "0"
+		}
+		flipped += label + string(line[1:]) + "\n"
+	}
+	path := filepath.Join(t.TempDir(), "flipped.svm")
+	if err := os.WriteFile(path, []byte(flipped), 0644); err != nil {
+		t.Fatal(err)
+	}
+	valid := loadSVM(t, path, true)
+
+	params := MakeParams(0.1, 1.0, 0.0, 0.1, 1000, 0.0, 1e-4, 20, 'b')
+	params.SetPatience(2)
+	stopped := MakeFTRL(params)
+	stopped.Fit(d, valid)
+
+	params = MakeParams(0.1, 1.0, 0.0, 0.1, 1000, 0.0, 1e-4, 1, 'b')
+	single := MakeFTRL(params)
+	single.Fit(d, nil)
+
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if stopped.Predict(x) != single.Predict(x) {
+			t.Errorf("row %d: weights of the best epoch are not restored", i)
+		}
+	}
+}
+
+func TestRegressionLinks(t *testing.T) {
+	// targets depend on a single feature: 3.0 with
+	// feature 0 present and 1.0 otherwise
+	data := ""
+	for i := 0; i < 50; i++ {
+		data += "3.0 0:1 2:1\n1.0 1:1 2:1\n"
+	}
+	path := filepath.Join(t.TempDir(), "reg.svm")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := loadSVM(t, path, true)
+	if d.MeanTarget() != 2.0 {
+		t.Errorf("expected mean target 2.0, got %v", d.MeanTarget())
+	}
+
+	for _, activation := range []rune{'g', 'p'} {
+		params := MakeParams(0.1, 1.0, 0.0, 0.0, 1000, 0.0, 1e-4, 20, activation)
+		model := MakeFTRL(params)
+		model.Fit(d, nil)
+
+		for i, expected := range []float64{3.0, 1.0} {
+			p := model.Predict(d.Row(uint64(i)))
+			if math.Abs(p-expected) > 0.05 {
+				t.Errorf("%c: row %d predicts %v, expected %v", activation, i, p, expected)
+			}
+		}
+
+		loss, _ := model.Validate(d)
+		if loss > 0.01 {
+			t.Errorf("%c: loss %v is too large", activation, loss)
+		}
+	}
+}
+
+func TestParallelTraining(t *testing.T) {
+	d := toyDataset(t)
+	params := MakeParams(0.1, 1.0, 0.0, 0.1, 1000, 0.3, 1e-4, 5, 'b')
+	params.SetSeed(3)
+	params.SetWorkers(4, true)
+
+	first := MakeFTRL(params)
+	first.Fit(d, nil)
+	second := MakeFTRL(params)
+	second.Fit(d, nil)
+
+	params.SetWorkers(4, false)
+	hogwild := MakeFTRL(params)
+	hogwild.Fit(d, nil)
+
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if first.Predict(x) != second.Predict(x) {
+			t.Errorf("row %d: deterministic mode gives different models", i)
+		}
+		if p := hogwild.Predict(x); math.IsNaN(p) || p <= 0 || p >= 1 {
+			t.Errorf("row %d: hogwild predicts %v", i, p)
+		}
+	}
+}
+
+func TestFitStreamMatchesFit(t *testing.T) {
+	d := toyDataset(t)
+	model := toyModel(t, d)
+
+	params := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.0, 1e-4, 3, 'b')
+	streamed := MakeFTRL(params)
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(toySVM)), nil
+	}
+	if _, err := streamed.FitStream(open, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if streamed.Predict(x) != model.Predict(x) {
+			t.Errorf("row %d: predictions differ", i)
+		}
+	}
+}
+
+func TestOnlineUpdate(t *testing.T) {
+	d := toyDataset(t)
+	model := toyModel(t, d)
+
+	params := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.0, 1e-4, 3, 'b')
+	online := MakeFTRL(params)
+	var e, i uint64
+	for e = 0; e < 3; e++ {
+		for i = 0; i < d.NRows(); i++ {
+			p := online.Predict(d.Row(i))
+			if got := online.PredictAndUpdate(d.Row(i), d.Label(i), 1.0); got != p {
+				t.Errorf("row %d: PredictAndUpdate returns %v, expected %v", i, got, p)
+			}
+		}
+	}
+
+	for i = 0; i < d.NRows(); i++ {
+		x := d.Row(i)
+		if online.Predict(x) != model.Predict(x) {
+			t.Errorf("row %d: predictions differ", i)
+		}
+	}
+
+	// unseen features grow the model concurrently
+	// with predictions
+	done := make(chan bool)
+	go func() {
+		for k := uint64(0); k < 100; k++ {
+			online.Update(util.Sample{{Key: 100 + k, Value: 1}}, 1, 1.0)
+		}
+		done <- true
+	}()
+	for k := uint64(0); k < 100; k++ {
+		online.Predict(util.Sample{{Key: 200 - k, Value: 1}})
+	}
+	<-done
+	if len(online.GetWeights()) <= len(model.GetWeights()) {
+		t.Error("unseen features are not learned")
+	}
+
+	// wide keys are ignored by dense store and
+	// learned by sharded one
+	wide := util.Sample{{Key: 1 << 62, Value: 1}}
+	before := len(online.GetWeights())
+	online.Update(wide, 1, 1.0)
+	if len(online.GetWeights()) != before {
+		t.Error("dense store learns wide key")
+	}
+	sharded := MakeFTRL(params)
+	sharded.SetWeightStore(MakeShardedStore(4))
+	sharded.Update(wide, 1, 1.0)
+	if _, ok := sharded.GetWeights()[1<<62]; !ok {
+		t.Error("sharded store does not learn wide key")
+	}
+}
+
+func TestWeightStores(t *testing.T) {
+	d := toyDataset(t)
+	reference := toyModel(t, d)
+
+	stores := map[string]func() WeightStore{
+		"dense":   func() WeightStore { return MakeDenseStore() },
+		"sharded": func() WeightStore { return MakeShardedStore(4) },
+		"hashed":  func() WeightStore { return MakeHashedStore(16) },
+	}
+	for name, makeStore := range stores {
+		params := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.0, 1e-4, 3, 'b')
+		model := MakeFTRL(params)
+		model.SetWeightStore(makeStore())
+		model.Fit(d, nil)
+
+		path := filepath.Join(t.TempDir(), name+".bin")
+		if err := model.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded := MakeFTRL(Params{})
+		if err := loaded.Load(path); err != nil {
+			t.Fatal(err)
+		}
+		kind, _ := model.weights.describe()
+		if loadedKind, _ := loaded.weights.describe(); loadedKind != kind {
+			t.Errorf("%s: store kind is not restored", name)
+		}
+
+		var i uint64
+		for i = 0; i < d.NRows(); i++ {
+			x := d.Row(i)
+			// no collisions on toy data, so every store
+			// learns the same model
+			if model.Predict(x) != reference.Predict(x) {
+				t.Errorf("%s: row %d: predictions differ from dense", name, i)
+			}
+			if loaded.Predict(x) != model.Predict(x) {
+				t.Errorf("%s: row %d: predictions differ after load", name, i)
+			}
+		}
+
+		unknown := util.Sample{{Key: 1 << 40, Value: 1}}
+		if p := model.Predict(unknown); name != "hashed" && p != model.activation(0) {
+			t.Errorf("%s: unknown feature affects prediction: %v", name, p)
+		}
+
+		// slots of hashed store have no names
+		var buf bytes.Buffer
+		if err := model.ToJSON(&buf, []string{"a", "b", "c", "d", "e", "f"}); err != nil {
+			t.Fatal(err)
+		}
+		if named := strings.Contains(buf.String(), `"name"`); named != (name != "hashed") {
+			t.Errorf("%s: names are exported: %v", name, named)
+		}
+	}
+
+	// keys above 32 bits are kept apart
+	wide := MakeFTRL(MakeParams(0.1, 1.0, 0.0, 0.0, 1000, 0.0, 1e-4, 3, 'b'))
+	wide.SetWeightStore(MakeShardedStore(4))
+	state := map[uint64]State{5: {Z: -1, N: 1}, 1<<32 + 5: {Z: 2, N: 1}}
+	wide.SetState(state)
+	if !reflect.DeepEqual(wide.GetState(), state) || len(wide.GetWeights()) != 2 {
+		t.Errorf("wide keys are lost: %v", wide.GetState())
+	}
+	var buf bytes.Buffer
+	if err := wide.ToJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	var decoded jsonModel
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Weights) != 2 ||
+		decoded.Weights[1].Index != 1<<32+5 {
+		t.Errorf("wide keys are not exported: %+v, err=%v", decoded.Weights, err)
+	}
+}
+
+func TestCrosses(t *testing.T) {
+	// label is XOR of two fields, so it can not
+	// be learned without crosses
+	data := ""
+	for i := 0; i < 20; i++ {
+		data += "1 0:1 3:1\n1 1:1 2:1\n0 0:1 2:1\n0 1:1 3:1\n"
+	}
+	path := filepath.Join(t.TempDir(), "xor.svm")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := loadSVM(t, path, true)
+	fields := util.MakeFieldRanges([]uint64{0, 2})
+
+	params := MakeParams(0.5, 1.0, 0.0, 0.0, 1000, 0.0, 1e-4, 10, 'b')
+	linear := MakeFTRL(params)
+	linear.Fit(d, nil)
+	crossed := MakeFTRL(params)
+	if err := crossed.SetCrosses(AllPairs(0, 1), fields, 10, d.NCols()); err != nil {
+		t.Fatal(err)
+	}
+	crossed.Fit(d, nil)
+
+	linearLoss, _ := linear.Validate(d)
+	crossedLoss, _ := crossed.Validate(d)
+	if crossedLoss > 0.1 || linearLoss < 0.6 {
+		t.Errorf("crosses are not learned: loss %v vs linear %v", crossedLoss, linearLoss)
+	}
+
+	// crossed keys do not depend on order of features
+	same := MakeFTRL(params)
+	if err := same.SetCrosses([]Cross{{0, 0}, {0, 0, 0}, {0, 1, 0}}, util.MakeFieldRanges([]uint64{0, 10}), 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	crossedKeys := func(x util.Sample) []uint64 {
+		var keys []uint64
+		for _, f := range same.crosses.expand(x, nil)[len(x):] {
+			keys = append(keys, f.Key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		return keys
+	}
+	sorted := crossedKeys(util.Sample{{Key: 1, Value: 1}, {Key: 2, Value: 1}, {Key: 5, Value: 1}, {Key: 11, Value: 1}})
+	shuffled := crossedKeys(util.Sample{{Key: 5, Value: 1}, {Key: 11, Value: 1}, {Key: 2, Value: 1}, {Key: 1, Value: 1}})
+	if len(sorted) != 3+1+3 || !reflect.DeepEqual(sorted, shuffled) {
+		t.Errorf("crossed keys depend on order: %v vs %v", sorted, shuffled)
+	}
+	for _, cross := range []Cross{{0}, {0, 1, 0, 1}} {
+		if err := same.SetCrosses([]Cross{cross}, fields, 10, 20); err == nil {
+			t.Errorf("cross %v is accepted", cross)
+		}
+	}
+
+	// workers of Hogwild must not grow dense table
+	hogwild := params
+	hogwild.SetWorkers(4, false)
+	parallel := MakeFTRL(hogwild)
+	if err := parallel.SetCrosses(AllPairs(0, 1), fields, 10, d.NCols()); err != nil {
+		t.Fatal(err)
+	}
+	parallel.Fit(d, nil)
+	if _, size := parallel.weights.describe(); size < d.NCols()+1<<10 {
+		t.Errorf("crossed keys are not reserved, table of size %d", size)
+	}
+	if n := len(parallel.GetState()); n > 20 {
+		t.Errorf("%d weights are kept, reserved ones must not be", n)
+	}
+
+	// exported crosses tell field of every key
+	var buf bytes.Buffer
+	if err := crossed.ToJSON(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	var decoded jsonModel
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.CrossFields == nil || !reflect.DeepEqual(decoded.CrossFields.Starts, []uint64{0, 2}) {
+		t.Errorf("fields are not exported: %+v", decoded.CrossFields)
+	}
+
+	// crosses are part of saved model
+	path = filepath.Join(t.TempDir(), "model.bin")
+	if err := crossed.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := MakeFTRL(Params{})
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	var i uint64
+	for i = 0; i < 4; i++ {
+		if loaded.Predict(d.Row(i)) != crossed.Predict(d.Row(i)) {
+			t.Errorf("row %d: predictions differ after load", i)
+		}
+	}
+}
+
+func TestHotPathDoesNotAllocatePerSample(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big.svm")
+	if err := os.WriteFile(path, []byte(strings.Repeat(toySVM, 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSVM(t, path, true).SaveBinary(path + ".bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// mapped dataset has no cached rows
+	d := util.MakeDataset()
+	if err := d.MapBinary(path + ".bin"); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	model := toyModel(t, d)
+
+	// goroutines and result slices are allocated per call
+	limit := float64(d.NRows()) / 10
+	checks := map[string]func(){
+		"epochRun":     func() { epochRun(model, d, nil) },
+		"Validate":     func() { model.Validate(d) },
+		"PredictBatch": func() { model.PredictBatch(d) },
+	}
+	for name, f := range checks {
+		if allocs := testing.AllocsPerRun(5, f); allocs > limit {
+			t.Errorf("%s: %v allocations for %d rows", name, allocs, d.NRows())
+		}
+	}
+}
+
+func TestCrossValidate(t *testing.T) {
+	d := toyDataset(t)
+	params := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.0, 1e-4, 3, 'b')
+
+	result, err := CrossValidate(d, 4, params, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Folds) != 4 {
+		t.Fatalf("%d folds, expected 4", len(result.Folds))
+	}
+	var loss float64
+	for i, fold := range result.Folds {
+		if fold.AUC < 0 || fold.AUC > 1 || fold.Nnz > int(d.NCols()) {
+			t.Errorf("fold %d: %+v", i, fold)
+		}
+		loss += fold.Loss / 4
+	}
+	if math.Abs(result.Loss-loss) > 1e-12 || result.LossStd < 0 {
+		t.Errorf("loss %v±%v, mean of folds %v", result.Loss, result.LossStd, loss)
+	}
+
+	if parallel, _ := CrossValidate(d, 4, params, true); !reflect.DeepEqual(parallel, result) {
+		t.Errorf("parallel run differs: %v vs %v", parallel, result)
+	}
+	if _, err := CrossValidate(d, 1, params, false); err == nil {
+		t.Error("one fold is accepted")
+	}
+
+	// held out fold is not seen by early stopping
+	path := filepath.Join(t.TempDir(), "big.svm")
+	if err := os.WriteFile(path, []byte(strings.Repeat(toySVM, 5)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	big := loadSVM(t, path, true)
+	result, err = CrossValidate(big, 4, params, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folds, err := big.KFold(4, params.seed, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := folds[0].Train.SplitStratified(params.seed, 1-cvInnerValid)
+	if err != nil || parts[1].NRows() == 0 {
+		t.Fatalf("no inner validation part: %v", err)
+	}
+	model := MakeFTRL(params)
+	model.Fit(parts[0], parts[1])
+	if loss, _ := evaluate(model, folds[0].Valid); loss != result.Folds[0].Loss {
+		t.Errorf("fold loss %v, expected %v", result.Folds[0].Loss, loss)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := toyDataset(t)
+	parts, err := d.SplitOrdered(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := MakeParams(0.1, 1.0, 0.01, 0.1, 1000, 0.0, 1e-4, 3, 'b')
+	space := SearchSpace{
+		"alpha": {Min: 0.05, Max: 0.5, Log: true},
+		"l1":    {Values: []float64{0, 0.1}},
+	}
+
+	_, grid, err := Search(parts[0], parts[1], base, space, SearchOptions{Method: GridSearch, Parallel: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grid) != 6 {
+		t.Errorf("grid search made %d trials, expected 6", len(grid))
+	}
+
+	for _, method := range []SearchMethod{RandomSearch, TPESearch} {
+		opts := SearchOptions{Method: method, MaxTrials: tpeStartup + 5}
+		best, board, err := Search(parts[0], parts[1], base, space, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(board) != opts.MaxTrials || best != board[0].Params {
+			t.Fatalf("method %d: %d trials, best %v", method, len(board), best)
+		}
+		for i, trial := range board {
+			if trial.Params.alpha < 0.05 || trial.Params.alpha > 0.5 || trial.Params.beta != 1.0 {
+				t.Errorf("method %d: trial %d params %v out of space", method, trial.ID, &trial.Params)
+			}
+			if i > 0 && trial.Loss < board[i-1].Loss {
+				t.Errorf("method %d: leaderboard is not sorted", method)
+			}
+		}
+
+		_, again, _ := Search(parts[0], parts[1], base, space, opts)
+		if !reflect.DeepEqual(again[0].Params, board[0].Params) {
+			t.Errorf("method %d: same seed gives different search", method)
+		}
+
+		var csvOut, jsonOut bytes.Buffer
+		if err := board.WriteCSV(&csvOut); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(csvOut.String(), "\n"); lines != len(board)+1 {
+			t.Errorf("CSV has %d lines", lines)
+		}
+		if err := board.WriteJSON(&jsonOut); err != nil {
+			t.Fatal(err)
+		}
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(jsonOut.Bytes(), &rows); err != nil || len(rows) != len(board) {
+			t.Errorf("JSON has %d rows, err=%v", len(rows), err)
+		}
+	}
+
+	if _, _, err := Search(d, nil, base, space, SearchOptions{Method: RandomSearch, MaxTrials: 1}); err == nil {
+		t.Error("search without validation dataset")
+	}
+	if _, _, err := Search(d, d, base, SearchSpace{"gamma": {Max: 1}}, SearchOptions{MaxTrials: 1}); err == nil {
+		t.Error("unknown hyperparameter is searched")
+	}
+}
+
+func TestFitTracksMetrics(t *testing.T) {
+	d := toyDataset(t)
+	parts, err := d.SplitOrdered(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := MakeParams(0.5, 1.0, 0.0, 0.0, 1000, 0.0, 1e-4, 20, 'b')
+	params.SetPatience(2)
+
+	// loss of Validate is mean logloss, so tracking
+	// logloss stops at the same epoch
+	plain := MakeFTRL(params)
+	plain.Fit(parts[0], parts[1])
+	tracked := MakeFTRL(params)
+	logloss, _ := metrics.Get("logloss")
+	auc, _ := metrics.Get("auc")
+	tracked.SetMetrics(logloss, auc)
+	tracked.Fit(parts[0], parts[1])
+	if !reflect.DeepEqual(plain.GetState(), tracked.GetState()) {
+		t.Error("tracking logloss changes training")
+	}
+
+	// calibration ratio is logged, but loss still
+	// chooses the best epoch
+	calibration, _ := metrics.Get("calibration")
+	logged := MakeFTRL(params)
+	logged.SetMetrics(calibration)
+	history := logged.Fit(parts[0], parts[1])
+	if !reflect.DeepEqual(plain.GetState(), logged.GetState()) {
+		t.Error("calibration ratio chooses best epoch")
+	}
+	if _, ok := history.Epochs[0].Metrics["calibration"]; !ok {
+		t.Error("calibration ratio is not logged")
+	}
+
+	byAUC := MakeFTRL(params)
+	byAUC.SetMetrics(auc)
+	byAUC.Fit(parts[0], parts[1])
+	if _, value := evaluate(byAUC, parts[1]); math.IsNaN(value) {
+		t.Error("AUC is not defined")
+	}
+}
+
+// stopper stops training at given epoch after
+// given number of samples and counts calls
+type stopper struct {
+	NopCallback
+	epoch, samples uint64
+	begins, ends   int
+	seen           []uint64
+}
+
+func (s *stopper) OnEpochBegin(epoch uint64) bool {
+	s.begins++
+	return false
+}
+
+func (s *stopper) OnEpochEnd(epoch uint64, record *EpochRecord) bool {
+	s.ends++
+	return false
+}
+
+func (s *stopper) OnSamples(epoch, seen uint64) bool {
+	s.seen = append(s.seen, seen)
+	return epoch == s.epoch && seen >= s.samples
+}
+
+func TestFitHistory(t *testing.T) {
+	d := toyDataset(t)
+	parts, err := d.SplitOrdered(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := MakeParams(0.5, 1.0, 0.0, 0.0, 1000, 0.0, 1e-4, 3, 'b')
+	params.SetPatience(0)
+
+	model := MakeFTRL(params)
+	auc, _ := metrics.Get("auc")
+	model.SetMetrics(auc)
+	history := model.Fit(parts[0], parts[1])
+	if len(history.Epochs) != 3 || history.Stopped {
+		t.Fatalf("history has %d epochs, stopped=%v", len(history.Epochs), history.Stopped)
+	}
+	for i, record := range history.Epochs {
+		if record.Epoch != uint64(i+1) || record.Samples != parts[0].NRows() {
+			t.Errorf("epoch %d: %+v", i+1, record)
+		}
+		if math.IsNaN(record.ValidLoss) || math.IsNaN(record.Metrics["auc"]) || record.Nnz == 0 {
+			t.Errorf("epoch %d: %+v", i+1, record)
+		}
+	}
+	if history.BestEpoch == 0 || history.BestEpoch > 3 {
+		t.Errorf("best epoch %d", history.BestEpoch)
+	}
+
+	if history := MakeFTRL(params).Fit(d, nil); !math.IsNaN(history.Epochs[0].ValidLoss) || history.BestEpoch != 0 {
+		t.Errorf("history without validation: %+v", history)
+	}
+
+	// training stops in the middle of the second epoch
+	cb := &stopper{epoch: 2, samples: 4}
+	stopped := MakeFTRL(params)
+	stopped.SetCallbacks(2, cb)
+	history = stopped.Fit(d, nil)
+	if !history.Stopped || len(history.Epochs) != 2 || history.Epochs[1].Samples != 4 {
+		t.Fatalf("stopped history: %+v", history)
+	}
+	if cb.begins != 2 || cb.ends != 2 {
+		t.Errorf("%d begins and %d ends", cb.begins, cb.ends)
+	}
+	var want []uint64
+	for seen := uint64(2); seen <= d.NRows(); seen += 2 {
+		want = append(want, seen)
+	}
+	want = append(want, 2, 4)
+	if !reflect.DeepEqual(cb.seen, want) {
+		t.Errorf("samples reported at %v, want %v", cb.seen, want)
+	}
+
+	// Hogwild workers report their samples in chunks
+	hogwild := params
+	hogwild.SetWorkers(2, false)
+	cb = &stopper{}
+	counted := MakeFTRL(hogwild)
+	counted.SetCallbacks(1, cb)
+	counted.Fit(d, nil)
+	if want := []uint64{4, 8, 4, 8, 4, 8}; !reflect.DeepEqual(cb.seen, want) {
+		t.Errorf("hogwild samples reported at %v, want %v", cb.seen, want)
+	}
+}
+
+// canceler cancels context after given number
+// of samples of given epoch
+type canceler struct {
+	NopCallback
+	epoch, samples uint64
+	cancel         context.CancelFunc
+}
+
+func (c *canceler) OnSamples(epoch, seen uint64) bool {
+	if epoch == c.epoch && seen >= c.samples {
+		c.cancel()
+	}
+	return false
+}
+
+func TestFitContext(t *testing.T) {
+	d := toyDataset(t)
+	params := MakeParams(0.5, 1.0, 0.0, 0.0, 1000, 0.0, 1e-4, 5, 'b')
+	path := filepath.Join(t.TempDir(), "checkpoint.model")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	model := MakeFTRL(params)
+	model.SetCallbacks(1, &canceler{epoch: 2, samples: 3, cancel: cancel})
+	model.SetCheckpoint(path)
+	history, err := model.FitContext(ctx, d, d)
+
+	var cerr *CanceledError
+	if !errors.As(err, &cerr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if cerr.Epoch != 2 || cerr.Samples != 3 || cerr.Checkpoint != path {
+		t.Errorf("wrong error %+v", cerr)
+	}
+	if len(history.Epochs) != 2 || history.Epochs[1].Samples != 3 {
+		t.Errorf("wrong history %+v", history)
+	}
+
+	// model keeps weights of interrupted epoch
+	partial := MakeFTRL(params)
+	partial.Fit(d, nil)
+	if reflect.DeepEqual(model.GetState(), partial.GetState()) {
+		t.Error("canceled training is finished")
+	}
+	loaded := MakeFTRL(params)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(model.GetState(), loaded.GetState()) {
+		t.Error("checkpoint differs from model")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left: %v", err)
+	}
+
+	// failed save keeps previous checkpoint
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := partial.Save(path); err == nil {
+		t.Error("save over directory succeeds")
+	}
+	if err := loaded.Load(path); err != nil || !reflect.DeepEqual(model.GetState(), loaded.GetState()) {
+		t.Errorf("previous checkpoint is lost: %v", err)
+	}
+	os.Remove(path + ".tmp")
+
+	// done context stops training, prediction and validation
+	hogwild := params
+	hogwild.SetWorkers(2, false)
+	for _, p := range []Params{params, hogwild} {
+		model := MakeFTRL(p)
+		history, err := model.FitContext(ctx, d, nil)
+		if !errors.Is(err, context.Canceled) || len(history.Epochs) != 0 || len(model.GetWeights()) != 0 {
+			t.Errorf("training with done context: %v, %+v", err, history)
+		}
+	}
+	if _, err := model.PredictBatchContext(ctx, d); !errors.Is(err, context.Canceled) {
+		t.Errorf("prediction is not canceled: %v", err)
+	}
+	if loss, _, err := model.ValidateContext(ctx, d); !errors.Is(err, context.Canceled) || !math.IsNaN(loss) {
+		t.Errorf("validation is not canceled: %v", err)
+	}
+}
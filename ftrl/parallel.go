@@ -0,0 +1,159 @@
+package ftrl
+
+import (
+	"sync"
+
+	util "github.com/go-code/goFTRL/utils"
+)
+
+// syncBatchPerWorker is number of rows each worker
+// predicts before updates of the batch are applied
+const syncBatchPerWorker = 64
+
+// hogwildStepEvery is number of samples Hogwild worker
+// counts locally before they are added to progress,
+// so workers do not contend for shared counter
+const hogwildStepEvery = 64
+
+// epochRunHogwild splits dataset into contiguous chunks,
+// one per worker. Workers read and update shared weights
+// without any locks, as in "Hogwild!" by Niu et al.
+// Weights are read and written atomically, collisions
+// are rare on sparse data and only cost a lost update.
+// Weights of every training feature are created in
+// advance by Fit
+func epochRunHogwild(a *FTRL, d *util.Dataset, pr *progress) (float64, float64, uint64) {
+	nrows := d.NRows()
+	nworkers := a.params.nworkers
+	chunksize := (int(nrows) + nworkers - 1) / nworkers
+
+	losses := make([]float64, nworkers)
+	grads := make([]float64, nworkers)
+	wsums := make([]float64, nworkers)
+	counts := make([]uint64, nworkers)
+	var wg sync.WaitGroup
+	for i := 0; i < nworkers; i++ {
+		start := i * chunksize
+		end := start + chunksize
+		if uint64(end) > nrows {
+			end = int(nrows)
+		}
+		if start > end {
+			start = end
+		}
+		wg.Add(1)
+		go func(i, start, end int) {
+			st := newSampleState(a.params.seed + int64(i) + 1)
+			st.concurrent = true
+			// sums are kept in locals, as slots of
+			// neighbour workers share cache lines
+			var loss, grad, wsum float64
+			var count uint64
+			var x util.Sample
+			var pending uint64
+			for j := start; j < end; j++ {
+				idx := uint64(j)
+				x = d.RowInto(idx, x[:0])
+				y := d.Label(idx)
+				w := d.SampleWeight(idx)
+				p, g := processSampleWith(a, st, x, y, w)
+				loss += a.loss(p, y, w)
+				grad += g
+				wsum += w
+				count++
+				pending++
+				if pending == hogwildStepEvery {
+					pending = 0
+					if pr.step(hogwildStepEvery) {
+						break
+					}
+				}
+			}
+			if pending > 0 {
+				pr.step(pending)
+			}
+			losses[i], grads[i], wsums[i], counts[i] = loss, grad, wsum, count
+			wg.Done()
+		}(i, start, end)
+	}
+	wg.Wait()
+
+	var n uint64
+	for _, count := range counts {
+		n += count
+	}
+	return sum(losses) / sum(wsums), sum(grads) / float64(n), n
+}
+
+// epochRunSync processes dataset in batches. Predictions
+// of a batch are computed in parallel from the same
+// weights, then updates are applied sequentially in row
+// order. Every worker owns fixed rows of a batch and its
+// own random generator, so result is deterministic.
+// Callbacks may stop it between batches only
+func epochRunSync(a *FTRL, d *util.Dataset, pr *progress) (float64, float64, uint64) {
+	nrows := d.NRows()
+	nworkers := a.params.nworkers
+	batch := nworkers * syncBatchPerWorker
+
+	states := make([]*sampleState, nworkers)
+	for i := range states {
+		states[i] = newSampleState(a.params.seed + int64(i) + 1)
+	}
+	rows := make([]util.Sample, nworkers)
+	samples := make([]util.Sample, batch)
+	preds := make([]float64, batch)
+
+	var loss, grad, wsum float64
+	var n uint64
+	var wg sync.WaitGroup
+	for first := uint64(0); first < nrows; first += uint64(batch) {
+		size := batch
+		if first+uint64(size) > nrows {
+			size = int(nrows - first)
+		}
+
+		for i := 0; i < nworkers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				st := states[i]
+				for j := i * syncBatchPerWorker; j < (i+1)*syncBatchPerWorker && j < size; j++ {
+					rows[i] = d.RowInto(first+uint64(j), rows[i][:0])
+					// row and state buffers are reused by next
+					// row, samples keep the batch until update
+					samples[j] = append(samples[j][:0], st.prepare(a, rows[i])...)
+					preds[j] = a.score(samples[j], false)
+				}
+				wg.Done()
+			}(i)
+		}
+		wg.Wait()
+
+		for j := 0; j < size; j++ {
+			idx := first + uint64(j)
+			y := d.Label(idx)
+			w := d.SampleWeight(idx)
+			p := preds[j]
+			gw := p - y
+			update(a, samples[j], util.Clip(w*gw, a.params.clipgrad), false)
+
+			loss += a.loss(p, y, w)
+			grad += gw
+			wsum += w
+		}
+		n += uint64(size)
+		if pr.step(uint64(size)) {
+			break
+		}
+	}
+
+	return loss / wsum, grad / float64(n), n
+}
+
+func sum(vec []float64) float64 {
+	total := 0.0
+	for _, v := range vec {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,458 @@
+package ftrl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	util "github.com/go-code/goFTRL/utils"
+)
+
+const (
+	SearchOutputTemplate = "Trial %d. %v val.loss=%f auc=%f time=%v"
+)
+
+const (
+	// tpeStartup is number of random trials
+	// before TPE starts modelling results
+	tpeStartup = 10
+	// tpeGamma is share of best trials
+	// considered good by TPE
+	tpeGamma = 0.25
+	// tpeCandidates is number of candidates drawn
+	// from good density for every hyperparameter
+	tpeCandidates = 24
+	// gridPoints is number of grid values of
+	// range without explicit values
+	gridPoints = 3
+)
+
+// SearchMethod is strategy of hyperparameter search
+type SearchMethod int
+
+const (
+	// GridSearch tries every combination of grid values
+	GridSearch SearchMethod = iota
+	// RandomSearch draws hyperparameters independently
+	RandomSearch
+	// TPESearch draws hyperparameters which are likely
+	// among best trials and unlikely among the rest,
+	// as Tree-structured Parzen Estimator of Bergstra et al.
+	TPESearch
+)
+
+// Range is interval of values of hyperparameter. Log
+// ranges are sampled uniformly on log scale and need
+// positive Min. Grid search tries Values or, if they
+// are not set, evenly spaced points of interval
+type Range struct {
+	Min, Max float64
+	Log      bool
+	Values   []float64
+}
+
+// SearchSpace maps names of searched hyperparameters:
+// alpha, beta, l1, l2, clip and dropout to their ranges.
+// Other hyperparameters are taken from base params
+type SearchSpace map[string]Range
+
+// DefaultSearchSpace returns ranges of all
+// searchable hyperparameters
+func DefaultSearchSpace() SearchSpace {
+	return SearchSpace{
+		"alpha":   {Min: 0.01, Max: 1, Log: true},
+		"beta":    {Min: 0.1, Max: 10, Log: true},
+		"l1":      {Min: 1e-3, Max: 10, Log: true},
+		"l2":      {Min: 1e-3, Max: 10, Log: true},
+		"clip":    {Min: 1, Max: 1000, Log: true},
+		"dropout": {Min: 0, Max: 0.5},
+	}
+}
+
+// SearchOptions limit hyperparameter search. Search
+// stops after MaxTrials trials or when Timeout is over,
+// whatever comes first; running trials are finished.
+// Parallel trials are run simultaneously
+type SearchOptions struct {
+	Method    SearchMethod
+	MaxTrials int
+	Timeout   time.Duration
+	Parallel  int
+}
+
+// Trial is result of training with given params
+type Trial struct {
+	ID       int
+	Params   Params
+	Loss     float64
+	AUC      float64
+	Duration time.Duration
+}
+
+// Leaderboard is list of trials from best to worst
+type Leaderboard []Trial
+
+// Search trains model for every trial params on train
+// dataset and ranks them by loss on valid dataset, which
+// also serves early stopping. Returns params of the best
+// trial. Trials are reproducible for seed of base params
+// if they run one at a time
+func Search(train, valid *util.Dataset, base Params, space SearchSpace,
+	opts SearchOptions) (Params, Leaderboard, error) {
+	if valid == nil {
+		return base, nil, errors.New("ftrl: search needs validation dataset")
+	}
+	if err := space.validate(); err != nil {
+		return base, nil, err
+	}
+	if opts.Method != GridSearch && opts.MaxTrials <= 0 && opts.Timeout <= 0 {
+		return base, nil, errors.New("ftrl: search has no budget")
+	}
+	if base.patience == 0 {
+		base.patience = 2
+	}
+
+	s := &searcher{
+		base:  base,
+		space: space,
+		names: space.names(),
+		rng:   rand.New(rand.NewSource(base.seed)),
+	}
+	if opts.Method == GridSearch {
+		s.grid = space.grid(s.names)
+	}
+
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+	nworkers := opts.Parallel
+	if nworkers < 1 {
+		nworkers = 1
+	}
+
+	var mu sync.Mutex
+	var board Leaderboard
+	next := 0
+	var wg sync.WaitGroup
+	for i := 0; i < nworkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				expired := !deadline.IsZero() && time.Now().After(deadline)
+				if expired || (opts.MaxTrials > 0 && next >= opts.MaxTrials) {
+					mu.Unlock()
+					return
+				}
+				params, ok := s.suggest(opts.Method, next, board)
+				id := next
+				next++
+				mu.Unlock()
+				if !ok {
+					return
+				}
+
+				trial := runTrial(id, params, train, valid)
+				log.Printf(SearchOutputTemplate, trial.ID, &trial.Params,
+					trial.Loss, trial.AUC, trial.Duration)
+				mu.Lock()
+				board = append(board, trial)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(board) == 0 {
+		return base, nil, errors.New("ftrl: no trials within budget")
+	}
+	sort.SliceStable(board, func(i, j int) bool {
+		return lossKey(board[i].Loss) < lossKey(board[j].Loss)
+	})
+	return board[0].Params, board, nil
+}
+
+func runTrial(id int, params Params, train, valid *util.Dataset) Trial {
+	start := time.Now()
+	model := MakeFTRL(params)
+	model.Fit(train, valid)
+	loss, auc := evaluate(model, valid)
+	return Trial{
+		ID:       id,
+		Params:   params,
+		Loss:     loss,
+		AUC:      auc,
+		Duration: time.Since(start),
+	}
+}
+
+// lossKey orders diverged trials last
+func lossKey(loss float64) float64 {
+	if math.IsNaN(loss) {
+		return math.Inf(1)
+	}
+	return loss
+}
+
+// searcher suggests params of the next trial.
+// It is used under lock of Search
+type searcher struct {
+	base  Params
+	space SearchSpace
+	names []string
+	rng   *rand.Rand
+	grid  [][]float64
+}
+
+// suggest returns params of trial id given finished
+// trials, false if grid is exhausted
+func (s *searcher) suggest(method SearchMethod, id int, done Leaderboard) (Params, bool) {
+	p := s.base
+	switch {
+	case method == GridSearch:
+		if id >= len(s.grid) {
+			return p, false
+		}
+		for i, name := range s.names {
+			*p.field(name) = s.grid[id][i]
+		}
+	case method == TPESearch && len(done) >= tpeStartup:
+		s.suggestTPE(&p, done)
+	default:
+		for _, name := range s.names {
+			r := s.space[name]
+			lo, hi := r.bounds()
+			*p.field(name) = r.value(lo + s.rng.Float64()*(hi-lo))
+		}
+	}
+	return p, true
+}
+
+// suggestTPE draws every hyperparameter independently
+// maximizing ratio of its densities among good and bad
+// trials, both estimated by Parzen windows
+func (s *searcher) suggestTPE(p *Params, done Leaderboard) {
+	sorted := append(Leaderboard{}, done...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return lossKey(sorted[i].Loss) < lossKey(sorted[j].Loss)
+	})
+	ngood := int(math.Ceil(tpeGamma * float64(len(sorted))))
+
+	for _, name := range s.names {
+		r := s.space[name]
+		lo, hi := r.bounds()
+		good := make([]float64, 0, ngood)
+		bad := make([]float64, 0, len(sorted)-ngood)
+		for i, trial := range sorted {
+			x := r.scale(*trial.Params.field(name))
+			if i < ngood {
+				good = append(good, x)
+			} else {
+				bad = append(bad, x)
+			}
+		}
+
+		best, bestScore := lo, math.Inf(-1)
+		for c := 0; c < tpeCandidates; c++ {
+			x := parzenSample(good, lo, hi, s.rng)
+			score := parzenLogPdf(x, good, lo, hi) - parzenLogPdf(x, bad, lo, hi)
+			if score > bestScore {
+				best, bestScore = x, score
+			}
+		}
+		*p.field(name) = r.value(best)
+	}
+}
+
+// parzenWidth is width of gaussian kernels
+// of n points within [lo, hi]
+func parzenWidth(n int, lo, hi float64) float64 {
+	return (hi - lo) / (2 * math.Sqrt(float64(n+1)))
+}
+
+// parzenSample draws value from mixture of uniform
+// prior and gaussian kernels at points
+func parzenSample(points []float64, lo, hi float64, rng *rand.Rand) float64 {
+	i := rng.Intn(len(points) + 1)
+	if i == len(points) {
+		return lo + rng.Float64()*(hi-lo)
+	}
+	x := points[i] + rng.NormFloat64()*parzenWidth(len(points), lo, hi)
+	return math.Max(lo, math.Min(hi, x))
+}
+
+// parzenLogPdf returns log density of mixture
+// sampled by parzenSample
+func parzenLogPdf(x float64, points []float64, lo, hi float64) float64 {
+	if hi == lo {
+		return 0
+	}
+	sigma := parzenWidth(len(points), lo, hi)
+	pdf := 1 / (hi - lo)
+	for _, point := range points {
+		z := (x - point) / sigma
+		pdf += math.Exp(-z*z/2) / (sigma * math.Sqrt(2*math.Pi))
+	}
+	return math.Log(pdf / float64(len(points)+1))
+}
+
+// field returns pointer to hyperparameter
+// by its name or nil for unknown name
+func (p *Params) field(name string) *float64 {
+	switch name {
+	case "alpha":
+		return &p.alpha
+	case "beta":
+		return &p.beta
+	case "l1":
+		return &p.lambda1
+	case "l2":
+		return &p.lambda2
+	case "clip":
+		return &p.clipgrad
+	case "dropout":
+		return &p.dropout
+	}
+	return nil
+}
+
+func (space SearchSpace) validate() error {
+	if len(space) == 0 {
+		return errors.New("ftrl: empty search space")
+	}
+	for name, r := range space {
+		if new(Params).field(name) == nil {
+			return fmt.Errorf("ftrl: unknown hyperparameter %q", name)
+		}
+		if r.Min > r.Max || (r.Log && r.Min <= 0) {
+			return fmt.Errorf("ftrl: bad range of %s [%v, %v]", name, r.Min, r.Max)
+		}
+	}
+	return nil
+}
+
+// names returns searched hyperparameters in fixed order
+func (space SearchSpace) names() []string {
+	names := make([]string, 0, len(space))
+	for name := range space {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// grid returns all combinations of grid values
+// of hyperparameters in order of names
+func (space SearchSpace) grid(names []string) [][]float64 {
+	grid := [][]float64{{}}
+	for _, name := range names {
+		values := space[name].gridValues()
+		extended := make([][]float64, 0, len(grid)*len(values))
+		for _, point := range grid {
+			for _, v := range values {
+				extended = append(extended, append(append([]float64{}, point...), v))
+			}
+		}
+		grid = extended
+	}
+	return grid
+}
+
+func (r Range) gridValues() []float64 {
+	if len(r.Values) > 0 {
+		return r.Values
+	}
+	lo, hi := r.bounds()
+	if lo == hi {
+		return []float64{r.Min}
+	}
+	values := make([]float64, gridPoints)
+	for i := range values {
+		values[i] = r.value(lo + (hi-lo)*float64(i)/(gridPoints-1))
+	}
+	return values
+}
+
+// bounds returns range on sampling scale
+func (r Range) bounds() (float64, float64) {
+	return r.scale(r.Min), r.scale(r.Max)
+}
+
+// scale maps value to sampling scale
+func (r Range) scale(v float64) float64 {
+	if r.Log {
+		return math.Log(v)
+	}
+	return v
+}
+
+// value maps point of sampling scale to value
+// within range, despite rounding of exp
+func (r Range) value(x float64) float64 {
+	if r.Log {
+		x = math.Exp(x)
+	}
+	return math.Max(r.Min, math.Min(r.Max, x))
+}
+
+var leaderboardHeader = []string{
+	"id", "alpha", "beta", "l1", "l2", "clip", "dropout", "loss", "auc", "seconds",
+}
+
+func (t *Trial) record() []float64 {
+	p := t.Params
+	return []float64{
+		float64(t.ID), p.alpha, p.beta, p.lambda1, p.lambda2, p.clipgrad, p.dropout,
+		t.Loss, t.AUC, t.Duration.Seconds(),
+	}
+}
+
+// WriteCSV writes trials as CSV table with header
+func (b Leaderboard) WriteCSV(out io.Writer) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(leaderboardHeader); err != nil {
+		return err
+	}
+	row := make([]string, len(leaderboardHeader))
+	for i := range b {
+		for j, v := range b[i].record() {
+			row[j] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// WriteJSON writes trials as JSON array of objects
+// with fields named as columns of WriteCSV. Undefined
+// values, e.g. AUC of one class, are null
+func (b Leaderboard) WriteJSON(out io.Writer) error {
+	rows := make([]map[string]interface{}, len(b))
+	for i := range b {
+		rows[i] = make(map[string]interface{})
+		for j, v := range b[i].record() {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				rows[i][leaderboardHeader[j]] = nil
+			} else {
+				rows[i][leaderboardHeader[j]] = v
+			}
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
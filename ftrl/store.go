@@ -0,0 +1,317 @@
+package ftrl
+
+import (
+	"sync"
+)
+
+type storeKind uint8
+
+const (
+	denseStore storeKind = iota
+	shardedStore
+	hashedStore
+)
+
+// WeightStore keeps per coordinate FTRL state. Available
+// implementations are created by MakeDenseStore,
+// MakeShardedStore and MakeHashedStore
+type WeightStore interface {
+	// lookup returns weights of feature k or
+	// nil if feature is unknown
+	lookup(k uint64) *weights
+	// fetch returns weights of feature k, creating
+	// them if needed, or nil if store can not hold k
+	fetch(k uint64) *weights
+	// reserve prepares store for features below n. With
+	// concurrent set, fetch of such features must not
+	// modify the store itself
+	reserve(n uint64, concurrent bool)
+	// each calls f for every stored coordinate
+	each(f func(k uint64, w *weights))
+	// assign sets state of coordinate k as it is
+	// reported by each
+	assign(k uint64, w weights)
+	// clone returns deep copy of the store
+	clone() WeightStore
+	// empty returns store of the same kind without weights
+	empty() WeightStore
+	// describe returns kind of store and its size parameter
+	describe() (storeKind, uint64)
+}
+
+// makeStore restores empty store from its description.
+// Dense table is not allocated, it grows with weights
+func makeStore(kind storeKind, arg uint64) WeightStore {
+	switch kind {
+	case denseStore:
+		return MakeDenseStore()
+	case shardedStore:
+		return MakeShardedStore(int(arg))
+	case hashedStore:
+		return MakeHashedStore(uint(arg))
+	}
+	return nil
+}
+
+// DenseStore is a slice indexed by feature. Fastest
+// option for compact feature spaces. Grows on demand up
+// to 2^28 keys, features with larger keys are ignored,
+// so wide hashed keys need sharded or hashed store
+type DenseStore struct {
+	table []*weights
+}
+
+// MakeDenseStore creates empty dense store
+func MakeDenseStore() *DenseStore {
+	return &DenseStore{table: make([]*weights, 0)}
+}
+
+func (s *DenseStore) lookup(k uint64) *weights {
+	if k < uint64(len(s.table)) {
+		return s.table[k]
+	}
+	return nil
+}
+
+func (s *DenseStore) fetch(k uint64) *weights {
+	if k >= maxTableSize {
+		return nil
+	}
+	size := uint64(len(s.table))
+	if k >= size {
+		// at least double to keep growth amortized
+		if 2*size > k+1 {
+			s.reserve(2*size, false)
+		} else {
+			s.reserve(k+1, false)
+		}
+	}
+
+	w := s.table[k]
+	if w == nil {
+		w = &weights{}
+		s.table[k] = w
+	}
+	return w
+}
+
+func (s *DenseStore) reserve(n uint64, concurrent bool) {
+	if n > maxTableSize {
+		n = maxTableSize
+	}
+	if uint64(len(s.table)) < n {
+		grown := make([]*weights, n)
+		copy(grown, s.table)
+		s.table = grown
+	}
+
+	// workers must not race on creation of weights,
+	// missing ones are taken from a single block
+	if concurrent {
+		var missing int
+		for _, wptr := range s.table {
+			if wptr == nil {
+				missing++
+			}
+		}
+		block := make([]weights, missing)
+		for i, wptr := range s.table {
+			if wptr == nil {
+				s.table[i] = &block[0]
+				block = block[1:]
+			}
+		}
+	}
+}
+
+// each skips weights which never got any gradient,
+// e.g. created in advance by reserve
+func (s *DenseStore) each(f func(k uint64, w *weights)) {
+	for i, wptr := range s.table {
+		if wptr != nil && (wptr.ni != 0 || wptr.zi != 0) {
+			f(uint64(i), wptr)
+		}
+	}
+}
+
+func (s *DenseStore) assign(k uint64, w weights) {
+	if wptr := s.fetch(k); wptr != nil {
+		*wptr = w
+	}
+}
+
+func (s *DenseStore) clone() WeightStore {
+	var n int
+	s.each(func(k uint64, w *weights) {
+		n++
+	})
+	table := make([]*weights, len(s.table))
+	block := make([]weights, 0, n)
+	s.each(func(k uint64, w *weights) {
+		block = append(block, *w)
+		table[k] = &block[len(block)-1]
+	})
+	return &DenseStore{table: table}
+}
+
+func (s *DenseStore) empty() WeightStore {
+	return MakeDenseStore()
+}
+
+func (s *DenseStore) describe() (storeKind, uint64) {
+	return denseStore, uint64(len(s.table))
+}
+
+// ShardedStore is a hash map split into shards with
+// their own locks. Memory is spent only on seen
+// features, so it suits unbounded feature spaces
+type ShardedStore struct {
+	shards []storeShard
+}
+
+type storeShard struct {
+	sync.RWMutex
+	table map[uint64]*weights
+}
+
+// MakeShardedStore creates empty store with n shards
+func MakeShardedStore(n int) *ShardedStore {
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]storeShard, n)
+	for i := range shards {
+		shards[i].table = make(map[uint64]*weights)
+	}
+	return &ShardedStore{shards: shards}
+}
+
+func (s *ShardedStore) shard(k uint64) *storeShard {
+	return &s.shards[mix64(k)%uint64(len(s.shards))]
+}
+
+func (s *ShardedStore) lookup(k uint64) *weights {
+	shard := s.shard(k)
+	shard.RLock()
+	w := shard.table[k]
+	shard.RUnlock()
+	return w
+}
+
+func (s *ShardedStore) fetch(k uint64) *weights {
+	if w := s.lookup(k); w != nil {
+		return w
+	}
+
+	shard := s.shard(k)
+	shard.Lock()
+	w, ok := shard.table[k]
+	if !ok {
+		w = &weights{}
+		shard.table[k] = w
+	}
+	shard.Unlock()
+	return w
+}
+
+func (s *ShardedStore) reserve(n uint64, concurrent bool) {}
+
+func (s *ShardedStore) each(f func(k uint64, w *weights)) {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.RLock()
+		for k, w := range shard.table {
+			f(k, w)
+		}
+		shard.RUnlock()
+	}
+}
+
+func (s *ShardedStore) assign(k uint64, w weights) {
+	*s.fetch(k) = w
+}
+
+func (s *ShardedStore) clone() WeightStore {
+	result := MakeShardedStore(len(s.shards))
+	s.each(func(k uint64, w *weights) {
+		c := *w
+		result.shard(k).table[k] = &c
+	})
+	return result
+}
+
+func (s *ShardedStore) empty() WeightStore {
+	return MakeShardedStore(len(s.shards))
+}
+
+func (s *ShardedStore) describe() (storeKind, uint64) {
+	return shardedStore, uint64(len(s.shards))
+}
+
+// HashedStore is a fixed size table of 2^bits slots
+// indexed by hash of feature (hashing trick). Memory
+// is bounded, colliding features share a weight.
+// Coordinates reported by GetWeights, GetState and
+// expected by SetWeights, SetState are slots
+type HashedStore struct {
+	bits  uint
+	table []weights
+}
+
+// MakeHashedStore creates store with 2^bits slots
+func MakeHashedStore(bits uint) *HashedStore {
+	return &HashedStore{bits: bits, table: make([]weights, 1<<bits)}
+}
+
+// slot maps feature to index in the table
+func (s *HashedStore) slot(k uint64) uint64 {
+	return mix64(k) & (uint64(len(s.table)) - 1)
+}
+
+func (s *HashedStore) lookup(k uint64) *weights {
+	return &s.table[s.slot(k)]
+}
+
+func (s *HashedStore) fetch(k uint64) *weights {
+	return &s.table[s.slot(k)]
+}
+
+func (s *HashedStore) reserve(n uint64, concurrent bool) {}
+
+func (s *HashedStore) each(f func(k uint64, w *weights)) {
+	for i := range s.table {
+		w := &s.table[i]
+		if w.ni != 0 || w.zi != 0 {
+			f(uint64(i), w)
+		}
+	}
+}
+
+func (s *HashedStore) assign(k uint64, w weights) {
+	s.table[k&(uint64(len(s.table))-1)] = w
+}
+
+func (s *HashedStore) clone() WeightStore {
+	result := MakeHashedStore(s.bits)
+	copy(result.table, s.table)
+	return result
+}
+
+func (s *HashedStore) empty() WeightStore {
+	return MakeHashedStore(s.bits)
+}
+
+func (s *HashedStore) describe() (storeKind, uint64) {
+	return hashedStore, uint64(s.bits)
+}
+
+// mix64 is finalizer of splitmix64, scatters
+// consecutive keys over the whole range
+func mix64(k uint64) uint64 {
+	k ^= k >> 30
+	k *= 0xbf58476d1ce4e5b9
+	k ^= k >> 27
+	k *= 0x94d049bb133111eb
+	k ^= k >> 31
+	return k
+}
@@ -0,0 +1,53 @@
+package ftrl
+
+import (
+	"io"
+	"log"
+
+	util "github.com/go-code/goFTRL/utils"
+)
+
+const (
+	StreamOutputTemplate = "#%02d. samples=%d progressive.loss=%f"
+)
+
+// FitStream trains model in a single pass over samples
+// in libsvm format per epoch, without loading them into
+// memory. Source is reopened by open before each of
+// niter passes. Loss of every sample is measured before
+// model learns from it (progressive validation), so
+// returned loss of the last pass estimates out of
+// sample performance. Samples are never shuffled
+func (a *FTRL) FitStream(open func() (io.ReadCloser, error), isBinary bool) (float64, error) {
+	var loss float64
+	var e uint64
+	for e = 1; e <= a.params.niter; e++ {
+		source, err := open()
+		if err != nil {
+			return loss, err
+		}
+
+		var count uint64
+		var sumLoss, sumWeights float64
+		scanner := util.MakeSVMScanner(source, isBinary)
+		for scanner.Scan() {
+			x, y := scanner.Sample(), scanner.Label()
+			p, _ := processSample(a, x, y, 1.0)
+
+			sumLoss += a.loss(p, y, 1.0)
+			sumWeights += 1.0
+			count++
+		}
+		source.Close()
+		if err := scanner.Err(); err != nil {
+			return loss, err
+		}
+
+		if sumWeights > 0 {
+			loss = sumLoss / sumWeights
+		}
+		log.Printf(StreamOutputTemplate, e, count, loss)
+	}
+
+	return loss, nil
+}
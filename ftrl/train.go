@@ -1,11 +1,15 @@
 package ftrl
 
 import (
+	"context"
 	"log"
 	"math"
+	"math/rand"
 	"runtime"
 	"sync"
+	"time"
 
+	"github.com/go-code/goFTRL/metrics"
 	util "github.com/go-code/goFTRL/utils"
 )
 
@@ -13,78 +17,233 @@ const (
 	DecisionOutputTemplate = "Weights count: %d. Nonzero: %d. Range: [%f, %f]"
 	TrainOutputTemplate    = "#%d. tr.loss=%f grad.norm=%f"
 	ValOutputTemplate      = "#%02d. tr.loss=%f val.loss=%f avg(pCTR)=%f grad.norm=%f"
+	StopOutputTemplate     = "Early stopping at #%02d. No improvement for %d epochs"
+	BestOutputTemplate     = "Restored weights of #%02d. val.%s=%f"
+	MetricsOutputTemplate  = "#%02d. %s"
 )
 
-// LinkFunction is an alias for activation function signature
-type LinkFunction func(float64) float64
-
 // FTRL is a structure for "Follow The Regularized Leader"
-// logistic regression algorithm
+// logistic regression algorithm. Predict, Update and
+// PredictAndUpdate are safe for concurrent use, while
+// Fit and other methods require exclusive access
 type FTRL struct {
-	weights    []*weights
+	mu sync.RWMutex
+
+	weights    WeightStore
 	params     Params
 	activation LinkFunction
+	loss       LossFunction
+	crosses    *crosser
+	tracked    []metrics.Metric
+	callbacks  []Callback
+	every      uint64
+	checkpoint string
+
+	local *sampleState
 }
 
 // MakeFTRL is fabric method for instance construction
 func MakeFTRL(p Params) *FTRL {
 
-	// Choose activation function
-	var f LinkFunction
-	if p.activation == 'b' {
-		// f = util.SigmoidLinear
-		f = util.SigmoidPiecewise
-		// f = util.Sigmoid
-	} else if p.activation == 'g' {
-		f = util.Identity
-	} else if p.activation == 'p' {
-		f = util.Exp
-	}
-
 	return &FTRL{
 		params:     p,
-		activation: f,
-		weights:    make([]*weights, 0)}
+		activation: linkByRune(p.activation),
+		loss:       lossByRune(p.activation),
+		weights:    MakeDenseStore(),
+		local:      newSampleState(p.seed)}
 }
 
 // Fit fits model for given dataset.
 // Validation dataset enables overfitting detection
 // mechanism, so final weights are chosen from best
-// validation logloss
-func (a *FTRL) Fit(train *util.Dataset, valid *util.Dataset) {
+// validation logloss or the first of metrics set by
+// SetMetrics. Training stops early when it does not
+// improve by more than tol for patience epochs, or
+// when callback set by SetCallbacks stops it. Rows
+// are visited in file order unless shuffling is set
+// in params. Returned history has record of every
+// trained epoch
+func (a *FTRL) Fit(train *util.Dataset, valid *util.Dataset) *History {
+	history, _ := a.FitContext(context.Background(), train, valid)
+	return history
+}
+
+// FitContext is Fit which stops when ctx is done. Context
+// is checked between samples, then *CanceledError wrapping
+// ctx.Err() is returned with history of trained epochs.
+// Model keeps weights of the interrupted epoch rather
+// than the best ones, and is saved to checkpoint if
+// SetCheckpoint is used
+func (a *FTRL) FitContext(ctx context.Context, train *util.Dataset, valid *util.Dataset) (*History, error) {
+	start := time.Now()
+	history := &History{}
+
 	numWeights := train.NCols()
 	if valid != nil {
 		if numWeights < valid.NCols() {
 			numWeights = valid.NCols()
 		}
 	}
-	a.initWeights(numWeights)
+	// crossed features are created by training too, so
+	// their keys are reserved for concurrent workers
+	if a.crosses != nil {
+		if end := a.crosses.offset + 1<<a.crosses.bits; numWeights < end {
+			numWeights = end
+		}
+	}
+	a.weights.reserve(numWeights, a.params.nworkers > 1 && !a.params.deterministic)
+
+	var labels, weights []float64
+	if valid != nil && len(a.tracked) > 0 {
+		labels, weights = targets(valid)
+	}
+
+	bestScore, bestValue := math.Inf(1), 0.0
+	var bestEpoch, lastEpoch, wait uint64
+	var best WeightStore
+	name := "loss"
 
 	var e uint64
 	for e = 1; e <= a.params.niter; e++ {
-		loss, gradnorm := epochRun(a, train)
-		if valid != nil {
-			lossVal, meanPred := a.Validate(valid)
+		if err := ctx.Err(); err != nil {
+			return history, a.interrupt(history, start, e, nil, err)
+		}
+		if a.epochBegin(e) {
+			log.Printf(CallbackStopOutputTemplate, e)
+			history.Stopped = true
+			break
+		}
+
+		lastEpoch = e
+		epochStart := time.Now()
+		epoch := train
+		if a.params.shuffle {
+			epoch = train.Shuffle(a.params.seed + int64(e))
+		}
+		pr := newProgress(a, e, ctx.Done())
+		loss, gradnorm, seen := epochRun(a, epoch, pr)
+		record := EpochRecord{
+			Epoch:     e,
+			TrainLoss: loss,
+			GradMean:  gradnorm,
+			ValidLoss: math.NaN(),
+			MeanPred:  math.NaN(),
+			Samples:   seen}
+		if err := ctx.Err(); err != nil {
+			record.Duration = time.Since(epochStart)
+			return history, a.interrupt(history, start, e, &record, err)
+		}
+
+		var score, value float64
+		if valid == nil {
+			log.Printf(TrainOutputTemplate, e, loss, gradnorm)
+		} else {
+			lossVal, meanPred, err := a.ValidateContext(ctx, valid)
+			if err != nil {
+				record.Duration = time.Since(epochStart)
+				return history, a.interrupt(history, start, e, &record, err)
+			}
 			log.Printf(ValOutputTemplate, e, loss, lossVal, meanPred, gradnorm)
-			continue
+			record.ValidLoss, record.MeanPred = lossVal, meanPred
+
+			value, score = lossVal, lossVal
+			if len(a.tracked) > 0 {
+				values, err := a.evalMetrics(ctx, valid, labels, weights)
+				if err != nil {
+					record.Duration = time.Since(epochStart)
+					return history, a.interrupt(history, start, e, &record, err)
+				}
+				log.Printf(MetricsOutputTemplate, e, formatMetrics(a.tracked, values))
+				record.Metrics = make(map[string]float64, len(values))
+				for i, m := range a.tracked {
+					record.Metrics[m.Name] = values[i]
+				}
+				if i := stoppingMetric(a.tracked); i >= 0 {
+					name, value, score = a.tracked[i].Name, values[i], values[i]
+					if a.tracked[i].Maximize {
+						score = -score
+					}
+				}
+			}
+		}
+
+		record.Nnz = a.nnz()
+		record.Duration = time.Since(epochStart)
+		history.Epochs = append(history.Epochs, record)
+
+		stop := pr.stop()
+		if a.epochEnd(e, &history.Epochs[len(history.Epochs)-1]) {
+			stop = true
 		}
-		log.Printf(TrainOutputTemplate, e, loss, gradnorm)
+
+		if valid != nil {
+			if score < bestScore-a.params.tol {
+				bestScore = score
+				bestValue = value
+				bestEpoch = e
+				best = a.weights.clone()
+				wait = 0
+			} else {
+				wait++
+			}
+		}
+
+		if stop {
+			log.Printf(CallbackStopOutputTemplate, e)
+			history.Stopped = true
+			break
+		}
+		if valid != nil && a.params.patience > 0 && wait >= a.params.patience {
+			log.Printf(StopOutputTemplate, e, wait)
+			break
+		}
+	}
+
+	history.BestEpoch = bestEpoch
+	if best != nil && bestEpoch != lastEpoch {
+		a.weights = best
+		log.Printf(BestOutputTemplate, bestEpoch, name, bestValue)
 	}
+	history.Duration = time.Since(start)
+	return history, nil
 }
 
-func (a *FTRL) initWeights(n uint64) {
-	a.weights = make([]*weights, n)
+// SetWeightStore replaces storage of weights, e.g. with
+// hashed table for unbounded feature spaces. Learned
+// weights are dropped
+func (a *FTRL) SetWeightStore(s WeightStore) {
+	a.weights = s
 }
 
 // Predict return probability estimation of positive outcome
 // for given sample
 func (a *FTRL) Predict(s util.Sample) float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.predict(s)
+}
+
+// predict is Predict without locking. Features
+// unknown to the model are ignored
+func (a *FTRL) predict(s util.Sample) float64 {
+	if a.crosses != nil {
+		return a.crosses.score(a, s)
+	}
+	return a.score(s, false)
+}
+
+// score is predict for sample which is already
+// extended with crossed features. Weights are read
+// atomically if concurrent is set, see weights.load
+func (a *FTRL) score(s util.Sample, concurrent bool) float64 {
 	var p float64
-	var w *weights
 	for _, feature := range s {
 		k, v := feature.Key, feature.Value
-		if a.weights[k] != nil {
-			w = a.weights[k]
+		if w := a.weights.lookup(k); w != nil {
+			if concurrent {
+				state := w.load()
+				w = &state
+			}
 			p += w.get(a.params) * v
 		}
 	}
@@ -95,9 +254,17 @@ func (a *FTRL) Predict(s util.Sample) float64 {
 // PredictBatch return probability estimations for every
 // sample in dataset
 func (a *FTRL) PredictBatch(d *util.Dataset) []float64 {
+	predicts, _ := a.PredictBatchContext(context.Background(), d)
+	return predicts
+}
+
+// PredictBatchContext is PredictBatch which stops when
+// ctx is done. Then ctx.Err() is returned together with
+// predictions, which are zero for skipped samples
+func (a *FTRL) PredictBatchContext(ctx context.Context, d *util.Dataset) ([]float64, error) {
 	nrows := d.NRows()
 	nworkers := runtime.NumCPU()
-	chunksize := int(nrows) / nworkers
+	chunksize := (int(nrows) + nworkers - 1) / nworkers
 	predicts := make([]float64, nrows)
 	var wg sync.WaitGroup
 	for i := 0; i < nworkers; i++ {
@@ -106,57 +273,82 @@ func (a *FTRL) PredictBatch(d *util.Dataset) []float64 {
 		if uint64(end) > nrows {
 			end = int(nrows)
 		}
+		if start > end {
+			start = end
+		}
 		wg.Add(1)
-		go predictBatchWorker(start, end, predicts, d, a, &wg)
+		go predictBatchWorker(start, end, predicts, d, a, ctx.Done(), &wg)
 	}
 	wg.Wait()
-	return predicts
+	return predicts, ctx.Err()
 }
 
-func predictBatchWorker(start int, end int, arr []float64, d *util.Dataset, a *FTRL, wg *sync.WaitGroup) {
+func predictBatchWorker(start int, end int, arr []float64, d *util.Dataset, a *FTRL,
+	done <-chan struct{}, wg *sync.WaitGroup) {
+	var x util.Sample
 	for j := start; j < end; j++ {
-		idx := uint64(j)
-		x := d.Row(idx)
-		p := a.Predict(x)
-		arr[j] = p
+		if (j-start)%checkEveryRows == 0 && canceled(done) {
+			break
+		}
+		x = d.RowInto(uint64(j), x[:0])
+		arr[j] = a.predict(x)
 	}
 	wg.Done()
 }
 
-// Save serializes model to file
-func (a *FTRL) Save() {
-	panic("Not implemented error")
-}
-
-// Load deserializes model from file
-func (a *FTRL) Load() {
-	panic("Not implemented error")
-}
-
-// ToJSON deserializes model weights to
-// json format as input to any inference
-// engine
-func (a *FTRL) ToJSON() {
-	panic("Not implemented error")
-}
-
 // GetWeights returns map index->weight for
 // nonzero weights
-func (a *FTRL) GetWeights() map[uint32]float64 {
-	result := make(map[uint32]float64)
-	for i, wptr := range a.weights {
+func (a *FTRL) GetWeights() map[uint64]float64 {
+	result := make(map[uint64]float64)
+	a.weights.each(func(k uint64, wptr *weights) {
 		w := wptr.get(a.params)
 		if w != 0 {
-			result[uint32(i)] = w
+			result[k] = w
 		}
+	})
+
+	return result
+}
+
+// SetWeights rebuilds model from map index->weight,
+// e.g. coefficients trained by another tool. Accumulated
+// squared gradients are kept for indexes known to the
+// model and start from zero for new ones
+func (a *FTRL) SetWeights(coefs map[uint64]float64) {
+	learned := a.GetState()
+	store := a.weights.empty()
+	for k, v := range coefs {
+		w := weights{ni: learned[k].N}
+		w.set(v, a.params)
+		store.assign(k, w)
 	}
+	a.weights = store
+}
+
+// State is raw per coordinate FTRL state
+type State struct {
+	Z, N float64
+}
+
+// GetState returns map index->state for every
+// coordinate touched by training
+func (a *FTRL) GetState() map[uint64]State {
+	result := make(map[uint64]State)
+	a.weights.each(func(k uint64, w *weights) {
+		result[k] = State{w.zi, w.ni}
+	})
 
 	return result
 }
 
-// SetWeights assigns weights to model
-func (a *FTRL) SetWeights() {
-	panic("Not implemented error")
+// SetState rebuilds model from raw FTRL state
+// so that training can continue from it
+func (a *FTRL) SetState(state map[uint64]State) {
+	store := a.weights.empty()
+	for k, s := range state {
+		store.assign(k, weights{ni: s.N, zi: s.Z})
+	}
+	a.weights = store
 }
 
 // GetParams returns model parameters
@@ -167,72 +359,165 @@ func (a *FTRL) GetParams() Params {
 // SetParams assigns model parameters
 func (a *FTRL) SetParams(p Params) {
 	a.params = p
+	a.local = newSampleState(p.seed)
+}
+
+// Update learns from a single labeled sample, growing
+// weight table for unseen features. Intended for online
+// learning loops, e.g. learning from click logs event
+// by event. Default dense store ignores keys of 2^28
+// and above, set sharded or hashed store by
+// SetWeightStore for wide hashed keys
+func (a *FTRL) Update(x util.Sample, label, weight float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	processSample(a, x, label, weight)
 }
 
-func processSample(a *FTRL, x util.Sample, y uint8, w float64) (float64, float64) {
-	p := a.Predict(x)
-	gw := (p - float64(y))
-	g := util.Clip(w*gw, a.params.clipgrad)
+// PredictAndUpdate returns prediction for sample made
+// before model learns from it
+func (a *FTRL) PredictAndUpdate(x util.Sample, label, weight float64) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
+	p := a.predict(x)
+	processSample(a, x, label, weight)
+	return p
+}
+
+// processSample makes one FTRL step on sample x. Gradient
+// of loss w.r.t. margin is p-y for every supported link,
+// because each of them is canonical for its loss
+func processSample(a *FTRL, x util.Sample, y float64, w float64) (float64, float64) {
+	return processSampleWith(a, a.local, x, y, w)
+}
+
+// processSampleWith is processSample with explicit
+// scratch state, so that it can run in many goroutines
+func processSampleWith(a *FTRL, st *sampleState, x util.Sample, y float64, w float64) (float64, float64) {
+	x = st.prepare(a, x)
+	p := a.score(x, st.concurrent)
+	gw := p - y
+	update(a, x, util.Clip(w*gw, a.params.clipgrad), st.concurrent)
+
+	return p, gw
+}
+
+// update applies gradient g of loss w.r.t. margin
+// to weights of every feature in x. Weights are
+// accessed atomically if concurrent is set
+func update(a *FTRL, x util.Sample, g float64, concurrent bool) {
 	for _, feature := range x {
 		k, v := feature.Key, feature.Value
-		if a.weights[k] == nil {
-			a.weights[k] = &weights{}
+		w := a.weights.fetch(k)
+		if w == nil {
+			continue
+		}
+		if !concurrent {
+			w.add(g*v, a.params)
+			continue
 		}
-		w := a.weights[k]
 
-		zi, ni := w.zi, w.ni
-		gi := g * v
-		sigma := (math.Sqrt(ni+gi*gi) - math.Sqrt(ni)) / a.params.alpha
-		wi := w.get(a.params)
-		zi = zi + gi - sigma*wi
-		ni = ni + (gi * gi)
+		state := w.load()
+		state.add(g*v, a.params)
+		w.store(state)
+	}
+}
+
+// sampleState is per goroutine scratch space of training
+type sampleState struct {
+	rng     *rand.Rand
+	crossed util.Sample
+	dropped util.Sample
+
+	// concurrent is set for Hogwild workers
+	concurrent bool
+}
+
+func newSampleState(seed int64) *sampleState {
+	return &sampleState{rng: rand.New(rand.NewSource(seed))}
+}
 
-		w.zi = zi
-		w.ni = ni
+// prepare returns sample which model learns from: x
+// extended with crossed features and thinned by
+// dropout. Result is valid until next call
+func (st *sampleState) prepare(a *FTRL, x util.Sample) util.Sample {
+	if a.crosses != nil {
+		x = a.crosses.expand(x, st.crossed[:0])
+		st.crossed = x
 	}
+	if a.params.dropout > 0 && a.params.dropout < 1 {
+		x = dropout(x, a.params.dropout, st.rng, st.dropped[:0])
+		st.dropped = x
+	}
+	return x
+}
 
-	return p, gw
+// dropout drops every feature of x with probability
+// rate and rescales kept ones by 1/(1-rate), so that
+// expected margin is the same as without dropout.
+// Result is written to buf
+func dropout(x util.Sample, rate float64, rng *rand.Rand, buf util.Sample) util.Sample {
+	scale := 1.0 / (1.0 - rate)
+	for _, feature := range x {
+		if rng.Float64() < rate {
+			continue
+		}
+		buf = append(buf, util.Feature{Key: feature.Key, Value: feature.Value * scale})
+	}
+	return buf
 }
 
-func epochRun(a *FTRL, d *util.Dataset) (float64, float64) {
+// epochRun trains one epoch on d and returns mean loss,
+// mean gradient and number of processed samples. Callbacks
+// or context of pr may stop it early, then means are
+// taken over processed samples only
+func epochRun(a *FTRL, d *util.Dataset, pr *progress) (float64, float64, uint64) {
+	if a.params.nworkers > 1 {
+		if a.params.deterministic {
+			return epochRunSync(a, d, pr)
+		}
+		return epochRunHogwild(a, d, pr)
+	}
+
 	nrows := d.NRows()
 	var i uint64
-	grad := make([]float64, nrows)
-	loss := 0.0
-	for ; i < nrows; i++ {
-		x := d.Row(i)
+	var x util.Sample
+	var loss, grad, wsum float64
+	for i < nrows {
+		x = d.RowInto(i, x[:0])
 		y := d.Label(i)
 		w := d.SampleWeight(i)
 		p, g := processSample(a, x, y, w)
 
-		grad[i] = g
-		loss += util.Logloss(p, y, w)
+		grad += g
+		loss += a.loss(p, y, w)
+		wsum += w
+		i++
+		if pr.step(1) {
+			break
+		}
 	}
 
-	return loss / d.WeightsSum(), util.Mean(grad)
+	return loss / wsum, grad / float64(i), i
 }
 
 // DecisionSummary prints summary about learned
 // weights
 func (a *FTRL) DecisionSummary() {
-	numWeights := uint64(len(a.weights))
-	var countNonzero int
+	var numWeights, countNonzero int
 	var minWeight, maxWeight float64
-	var i uint64
-	for i = 0; i < numWeights; i++ {
-		if a.weights[i] == nil {
-			continue
-		}
-
-		w := a.weights[i].get(a.params)
+	a.weights.each(func(k uint64, wptr *weights) {
+		numWeights++
+		w := wptr.get(a.params)
 		if w != 0.0 {
 			countNonzero++
 		}
 
 		minWeight = math.Min(minWeight, w)
 		maxWeight = math.Max(maxWeight, w)
-	}
+	})
 
 	log.Printf(DecisionOutputTemplate,
 		numWeights, countNonzero, minWeight, maxWeight)
@@ -1,22 +1,31 @@
 package ftrl
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"runtime"
+	"strings"
 
+	"github.com/go-code/goFTRL/metrics"
 	ml "github.com/go-code/goFTRL/utils"
 )
 
 func validateBatch(start, end int, valid *ml.Dataset, a *FTRL,
-	losses chan float64, predics chan float64) {
+	done <-chan struct{}, losses chan float64, predics chan float64) {
 	sumLoss := 0.0
 	sumPred := 0.0
+	var x ml.Sample
 	for j := start; j < end; j++ {
+		if (j-start)%checkEveryRows == 0 && canceled(done) {
+			break
+		}
 		idx := uint64(j)
-		x := valid.Row(idx)
-		p := a.Predict(x)
+		x = valid.RowInto(idx, x[:0])
+		p := a.predict(x)
 		y := valid.Label(idx)
 		w := valid.SampleWeight(idx)
-		loss := ml.Logloss(p, y, w)
+		loss := a.loss(p, y, w)
 		sumLoss += loss
 		sumPred += p
 	}
@@ -25,13 +34,21 @@ func validateBatch(start, end int, valid *ml.Dataset, a *FTRL,
 }
 
 // Validate performs parallel batch iteration through
-// the dataset. Computes logloss and avg. predicted
-// probability
+// the dataset. Computes loss of model link (logloss,
+// squared error or poisson deviance) and avg. prediction
 func (a *FTRL) Validate(valid *ml.Dataset) (float64, float64) {
+	loss, meanPred, _ := a.ValidateContext(context.Background(), valid)
+	return loss, meanPred
+}
+
+// ValidateContext is Validate which stops when ctx is
+// done. Then loss and avg. prediction are NaN and
+// ctx.Err() is returned
+func (a *FTRL) ValidateContext(ctx context.Context, valid *ml.Dataset) (float64, float64, error) {
 	nrows := valid.NRows()
 
 	nworkers := runtime.NumCPU()
-	chunksize := int(nrows) / nworkers
+	chunksize := (int(nrows) + nworkers - 1) / nworkers
 
 	losses := make(chan float64, nworkers)
 	predics := make(chan float64, nworkers)
@@ -41,7 +58,10 @@ func (a *FTRL) Validate(valid *ml.Dataset) (float64, float64) {
 		if uint64(end) > nrows {
 			end = int(nrows)
 		}
-		go validateBatch(start, end, valid, a, losses, predics)
+		if start > end {
+			start = end
+		}
+		go validateBatch(start, end, valid, a, ctx.Done(), losses, predics)
 	}
 
 	lossSum := 0.0
@@ -51,8 +71,70 @@ func (a *FTRL) Validate(valid *ml.Dataset) (float64, float64) {
 		pSum += <-predics
 	}
 
+	if err := ctx.Err(); err != nil {
+		return math.NaN(), math.NaN(), err
+	}
+
 	avPCTR := pSum / float64(nrows)
 	avLoss := lossSum / valid.WeightsSum()
 	// PE := avPCTR/valid.MeanTarget() - 1.0
-	return avLoss, avPCTR
+	return avLoss, avPCTR, nil
+}
+
+// SetMetrics makes Fit log given metrics of validation
+// dataset every epoch. The first of them which is not
+// log only replaces loss in choice of best epoch and
+// early stopping
+func (a *FTRL) SetMetrics(tracked ...metrics.Metric) {
+	a.tracked = tracked
+}
+
+// stoppingMetric returns index of tracked metric
+// choosing best epoch, or -1 if loss chooses it
+func stoppingMetric(tracked []metrics.Metric) int {
+	for i, m := range tracked {
+		if !m.LogOnly {
+			return i
+		}
+	}
+	return -1
+}
+
+// evalMetrics computes tracked metrics of model on
+// dataset with given labels and weights
+func (a *FTRL) evalMetrics(ctx context.Context, d *ml.Dataset, labels, weights []float64) ([]float64, error) {
+	preds, err := a.PredictBatchContext(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, len(a.tracked))
+	for i, m := range a.tracked {
+		values[i] = m.Eval(labels, preds, weights)
+	}
+	return values, nil
+}
+
+// targets returns labels of dataset and sample
+// weights or nil if dataset is not weighted
+func targets(d *ml.Dataset) ([]float64, []float64) {
+	labels := make([]float64, d.NRows())
+	weights := make([]float64, d.NRows())
+	weighted := false
+	for i := range labels {
+		labels[i] = d.Label(uint64(i))
+		weights[i] = d.SampleWeight(uint64(i))
+		weighted = weighted || weights[i] != 1
+	}
+	if !weighted {
+		weights = nil
+	}
+	return labels, weights
+}
+
+func formatMetrics(tracked []metrics.Metric, values []float64) string {
+	parts := make([]string, len(tracked))
+	for i, m := range tracked {
+		parts[i] = fmt.Sprintf("val.%s=%f", m.Name, values[i])
+	}
+	return strings.Join(parts, " ")
 }
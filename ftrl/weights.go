@@ -3,6 +3,8 @@ package ftrl
 import (
 	"fmt"
 	"math"
+	"sync/atomic"
+	"unsafe"
 
 	ml "github.com/go-code/goFTRL/utils"
 )
@@ -30,6 +32,46 @@ func (w *weights) get(p Params) float64 {
 	return wi
 }
 
+// set chooses zi so that get returns value
+// for current ni. Inverse of get
+func (w *weights) set(value float64, p Params) {
+	if value == 0 {
+		w.zi = 0.0
+		return
+	}
+
+	den := math.Sqrt(w.ni)
+	den += p.beta
+	den /= p.alpha
+	den += p.lambda2
+
+	w.zi = -value*den - ml.Sgn(value)*p.lambda1
+}
+
+// add applies gradient gi of loss w.r.t. weight
+func (w *weights) add(gi float64, p Params) {
+	sigma := (math.Sqrt(w.ni+gi*gi) - math.Sqrt(w.ni)) / p.alpha
+	w.zi += gi - sigma*w.get(p)
+	w.ni += gi * gi
+}
+
+// load reads state atomically. Hogwild workers share
+// weights without locks, atomic access keeps every
+// value whole, while updates of a coordinate by two
+// workers at once may still lose one of them
+func (w *weights) load() weights {
+	return weights{
+		ni: math.Float64frombits(atomic.LoadUint64((*uint64)(unsafe.Pointer(&w.ni)))),
+		zi: math.Float64frombits(atomic.LoadUint64((*uint64)(unsafe.Pointer(&w.zi)))),
+	}
+}
+
+// store writes state atomically, see load
+func (w *weights) store(s weights) {
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&w.ni)), math.Float64bits(s.ni))
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&w.zi)), math.Float64bits(s.zi))
+}
+
 func (w *weights) String() string {
 	return fmt.Sprintf("%v\t%v", w.ni, w.zi)
 }
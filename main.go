@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-code/goFTRL/ftrl"
+	"github.com/go-code/goFTRL/metrics"
 	ml "github.com/go-code/goFTRL/utils"
 )
 
@@ -15,7 +22,6 @@ const (
 )
 
 func main() {
-	// TODO add flag if to read binary dataset
 	// TODO add flag read fixed number of rows
 	// TODO enable profile if flag set
 
@@ -32,35 +38,108 @@ func main() {
 	l1 := flag.Float64("-l1", 0.5, "L1")
 	l2 := flag.Float64("-l2", 1.0, "L2")
 	clip := flag.Float64("-clip", 1000.0, "gradient clip value")
+	dropout := flag.Float64("-dropout", 0.0, "feature dropout probability")
+	seed := flag.Int64("-seed", 42, "random seed")
 	tol := flag.Float64("-tol", 1e-4, "tolerance")
+	patience := flag.Uint64("-patience", 2, "epochs without improvement before early stopping")
+	shuffle := flag.Bool("-shuffle", false, "shuffle TRAIN rows every epoch")
 
+	split := flag.Float64("-split", 0, "hold out given fraction of TRAIN as VALID instead of reading VALID file")
+	splitBy := flag.String("-splitby", "random", "holdout split: random, label (stratified) or time (last rows)")
+	mmap := flag.Bool("-mmap", false, "memory map .bin datasets instead of reading them")
+	lenient := flag.Bool("-lenient", false, "skip malformed lines of TRAIN and VALID instead of failing")
+	saveBin := flag.Bool("-savebin", false, "save parsed datasets next to text files with .bin suffix")
+	vwBits := flag.Uint("-vwbits", 0, "read raw VW-style files hashing features into given bits")
+	hashBits := flag.Uint("-hash", 0, "bits of hashed weight table, 0 for dense table")
+	metricNames := flag.String("-metrics", "", "comma separated VALID metrics to log, the first one is used for early stopping")
+	link := flag.String("-link", "b", "link function: b (logistic), g (gaussian), p (poisson)")
 	nEpoch := flag.Uint64("-e", 10, "number of epochs to train")
+	workers := flag.Int("-workers", 1, "number of training goroutines")
+	deterministic := flag.Bool("-deterministic", false, "make parallel training reproducible")
+	stream := flag.Bool("-stream", false, "train from TRAIN file in one pass per epoch without loading it")
 	bench := flag.Bool("-pprof", true, "enable profiling")
+	folds := flag.Int("-folds", 5, "number of folds of cv command")
+	cvParallel := flag.Bool("-cvparallel", false, "train models of cv command in parallel")
+	method := flag.String("-method", "tpe", "search command method: grid, random or tpe")
+	trials := flag.Int("-trials", 50, "max number of trials of search command")
+	budget := flag.Duration("-budget", 0, "time limit of search command")
+	parallel := flag.Int("-parallel", 1, "number of simultaneous trials of search command")
+	leaderboard := flag.String("-leaderboard", "", "path to save trials of search command, .json or .csv")
+	checkpoint := flag.String("-checkpoint", "", "path to save model if training is interrupted by SIGINT or SIGTERM")
 
-	flag.Parse()
+	// "cv" command cross-validates params on TRAIN,
+	// "search" command looks for best params on VALID
+	// instead of training a model
+	command, args := "train", os.Args[1:]
+	if len(args) > 0 && (args[0] == "cv" || args[0] == "search") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if command != "train" && *stream {
+		log.Fatalf("%s command can not read TRAIN as stream", command)
+	}
+	if *stream && *vwBits > 0 {
+		log.Fatal("stream of TRAIN is read in libsvm format, it can not be used with -vwbits")
+	}
 
 	if *bench {
 		log.Println("pprof enabled!")
 	}
 
+	var vectorizer *ml.Vectorizer
+	if *vwBits > 0 {
+		vectorizer = ml.MakeVectorizer(*vwBits, uint32(*seed), *vwBits > 32, false)
+	}
+
 	// Parse train
-	Dtrain := ml.MakeAndLoadDataset(*train, -1, true)
-	if *trainW != "" {
-		Dtrain.LoadSampleWeights(*trainW)
-		if *validF != "" {
-			Dtrain.LoadFeatureNames(*trainF)
+	var Dtrain *ml.Dataset
+	if !*stream {
+		Dtrain = loadDataset(*train, vectorizer, *saveBin, *mmap, *lenient)
+		if *trainW != "" {
+			if err := Dtrain.LoadSampleWeights(*trainW); err != nil {
+				log.Fatal(err)
+			}
+			if *validF != "" {
+				if err := Dtrain.LoadFeatureNames(*trainF); err != nil {
+					log.Fatal(err)
+				}
+			}
 		}
 	}
 
 	// Parse validation
 	var Dvalid *ml.Dataset
-	if *valid != "" {
-		Dvalid = ml.MakeAndLoadDataset(*valid, -1, true)
+	switch {
+	case command == "cv":
+		// folds are made of TRAIN
+	case *split > 0 && Dtrain != nil:
+		var parts []*ml.Dataset
+		var err error
+		switch *splitBy {
+		case "random":
+			parts, err = Dtrain.SplitRandom(*seed, 1-*split)
+		case "label":
+			parts, err = Dtrain.SplitStratified(*seed, 1-*split)
+		case "time":
+			parts, err = Dtrain.SplitOrdered(1 - *split)
+		default:
+			log.Fatalf("unknown split %q", *splitBy)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		Dtrain, Dvalid = parts[0], parts[1]
+	case *valid != "":
+		Dvalid = loadDataset(*valid, vectorizer, *saveBin, *mmap, *lenient)
 		if *validW != "" {
-			Dvalid.LoadSampleWeights(*validW)
+			if err := Dvalid.LoadSampleWeights(*validW); err != nil {
+				log.Fatal(err)
+			}
 		}
 		if *validF != "" {
-			Dvalid.LoadFeatureNames(*validF)
+			if err := Dvalid.LoadFeatureNames(*validF); err != nil {
+				log.Fatal(err)
+			}
 		}
 	}
 
@@ -78,14 +157,139 @@ func main() {
 	// Train model
 	params := ftrl.MakeParams(
 		*alpha, *beta, *l1, *l2,
-		*clip, 0.0, *tol,
-		*nEpoch, 'b')
+		*clip, *dropout, *tol,
+		*nEpoch, []rune(*link)[0])
+	params.SetSeed(*seed)
+	params.SetPatience(*patience)
+	params.SetShuffle(*shuffle)
+	params.SetWorkers(*workers, *deterministic)
+
+	if command == "cv" {
+		result, err := ftrl.CrossValidate(Dtrain, *folds, params, *cvParallel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println(result)
+		return
+	}
+	if command == "search" {
+		search(Dtrain, Dvalid, params, *method, *trials, *budget, *parallel, *leaderboard)
+		return
+	}
 
 	logreg := ftrl.MakeFTRL(params)
-	logreg.Fit(Dtrain, Dvalid)
+	if *metricNames != "" {
+		var tracked []metrics.Metric
+		for _, name := range strings.Split(*metricNames, ",") {
+			m, err := metrics.Get(strings.TrimSpace(name))
+			if err != nil {
+				log.Fatal(err)
+			}
+			tracked = append(tracked, m)
+		}
+		logreg.SetMetrics(tracked...)
+	}
+	if *hashBits > 0 {
+		logreg.SetWeightStore(ftrl.MakeHashedStore(*hashBits))
+	}
+	logreg.SetCheckpoint(*checkpoint)
+
+	// interrupted training keeps learned weights and
+	// saves checkpoint instead of losing progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *stream {
+		open := func() (io.ReadCloser, error) {
+			return os.Open(*train)
+		}
+		if _, err := logreg.FitStream(open, true); err != nil {
+			log.Fatal(err)
+		}
+	} else if _, err := logreg.FitContext(ctx, Dtrain, Dvalid); err != nil {
+		log.Println(err)
+		logreg.DecisionSummary()
+		return
+	}
 
 	p := logreg.PredictBatch(Dvalid)
 	log.Println(ml.Mean(p))
 
 	logreg.DecisionSummary()
 }
+
+// search runs hyperparameter search over default
+// space and saves leaderboard if path is given
+func search(train, valid *ml.Dataset, base ftrl.Params, method string,
+	trials int, budget time.Duration, parallel int, path string) {
+	methods := map[string]ftrl.SearchMethod{
+		"grid":   ftrl.GridSearch,
+		"random": ftrl.RandomSearch,
+		"tpe":    ftrl.TPESearch,
+	}
+	m, ok := methods[method]
+	if !ok {
+		log.Fatalf("unknown search method %q", method)
+	}
+
+	opts := ftrl.SearchOptions{Method: m, MaxTrials: trials, Timeout: budget, Parallel: parallel}
+	best, board, err := ftrl.Search(train, valid, base, ftrl.DefaultSearchSpace(), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Best of %d trials: %v", len(board), &best)
+
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if strings.HasSuffix(path, ".json") {
+		err = board.WriteJSON(f)
+	} else {
+		err = board.WriteCSV(f)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadDataset reads binary dataset if path has .bin
+// suffix, otherwise libsvm file or raw VW-style file
+// if vectorizer is given. Parsed text is optionally
+// cached in binary format for the next runs
+func loadDataset(path string, vectorizer *ml.Vectorizer, saveBin, mmap, lenient bool) *ml.Dataset {
+	d := ml.MakeDataset()
+	d.SetLenient(lenient)
+	if strings.HasSuffix(path, ".bin") {
+		load := d.LoadBinary
+		if mmap {
+			load = d.MapBinary
+		}
+		if err := load(path); err != nil {
+			log.Fatal(err)
+		}
+		return d
+	}
+
+	var err error
+	if vectorizer == nil {
+		err = d.FromSVMFile(path, -1, true)
+	} else {
+		err = d.FromVWFile(path, -1, vectorizer)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if saveBin {
+		if err := d.SaveBinary(path + ".bin"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return d
+}
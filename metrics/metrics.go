@@ -0,0 +1,277 @@
+// Package metrics evaluates quality of predictions.
+// Every metric takes labels, predictions and optional
+// sample weights, nil weights mean weight 1 of every
+// sample
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	util "github.com/go-code/goFTRL/utils"
+)
+
+// Metric is named quality measure of predictions
+type Metric struct {
+	Name string
+	// Eval computes metric, weights may be nil
+	Eval func(labels, preds, weights []float64) float64
+	// Maximize is set for metrics growing with quality
+	Maximize bool
+	// LogOnly is set for metrics which are good when close
+	// to some value, like calibration ratio, they are
+	// logged but never choose the best epoch
+	LogOnly bool
+}
+
+var registry = map[string]Metric{}
+
+func init() {
+	for _, m := range []Metric{
+		{"logloss", Logloss, false, false},
+		{"auc", AUC, true, false},
+		{"prauc", PRAUC, true, false},
+		{"ne", NormalizedEntropy, false, false},
+		{"calibration", Calibration, false, true},
+		{"calibration_error", CalibrationError, false, false},
+		{"rmse", RMSE, false, false},
+		{"mae", MAE, false, false},
+		{"poisson", PoissonDeviance, false, false},
+	} {
+		Register(m)
+	}
+}
+
+// Register makes metric available by its name,
+// replacing metric of the same name
+func Register(m Metric) {
+	registry[m.Name] = m
+}
+
+// Get returns registered metric by name
+func Get(name string) (Metric, error) {
+	m, ok := registry[name]
+	if !ok {
+		return Metric{}, fmt.Errorf("metrics: unknown metric %q", name)
+	}
+	return m, nil
+}
+
+// Names returns sorted names of registered metrics
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// weight returns weight of ith sample
+func weight(weights []float64, i int) float64 {
+	if weights == nil {
+		return 1.0
+	}
+	return weights[i]
+}
+
+// mean returns weighted mean of f over samples
+func mean(labels, preds, weights []float64, f func(p, y, w float64) float64) float64 {
+	var sum, wsum float64
+	for i := range preds {
+		w := weight(weights, i)
+		sum += f(preds[i], labels[i], w)
+		wsum += w
+	}
+	return sum / wsum
+}
+
+// Logloss returns weighted mean logloss
+func Logloss(labels, preds, weights []float64) float64 {
+	return mean(labels, preds, weights, util.Logloss)
+}
+
+// NormalizedEntropy returns logloss divided by logloss
+// of constant prediction of base rate, so it is below 1
+// when model is better than knowing the average only
+func NormalizedEntropy(labels, preds, weights []float64) float64 {
+	var sum, wsum float64
+	for i := range labels {
+		w := weight(weights, i)
+		sum += w * labels[i]
+		wsum += w
+	}
+	base := sum / wsum
+	entropy := mean(labels, preds, weights, func(p, y, w float64) float64 {
+		return util.Logloss(base, y, w)
+	})
+	return Logloss(labels, preds, weights) / entropy
+}
+
+// Calibration returns ratio of sum of predictions to
+// sum of labels, 1 for calibrated model. Unlike other
+// metrics it is good when close to 1, not when it is
+// small, so it is registered as log only metric and
+// CalibrationError serves early stopping instead
+func Calibration(labels, preds, weights []float64) float64 {
+	var sumPred, sumLabel float64
+	for i := range preds {
+		w := weight(weights, i)
+		sumPred += w * preds[i]
+		sumLabel += w * labels[i]
+	}
+	return sumPred / sumLabel
+}
+
+// CalibrationError returns distance of Calibration
+// from 1, 0 for calibrated model
+func CalibrationError(labels, preds, weights []float64) float64 {
+	return math.Abs(1 - Calibration(labels, preds, weights))
+}
+
+// RMSE returns root of weighted mean squared error
+func RMSE(labels, preds, weights []float64) float64 {
+	return math.Sqrt(mean(labels, preds, weights, util.SquaredError))
+}
+
+// MAE returns weighted mean absolute error
+func MAE(labels, preds, weights []float64) float64 {
+	return mean(labels, preds, weights, func(p, y, w float64) float64 {
+		return math.Abs(p-y) * w
+	})
+}
+
+// PoissonDeviance returns weighted mean poisson deviance
+func PoissonDeviance(labels, preds, weights []float64) float64 {
+	return mean(labels, preds, weights, util.PoissonDeviance)
+}
+
+// ranked returns samples ordered by decreasing prediction
+func ranked(preds []float64) []int {
+	order := make([]int, len(preds))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return preds[order[a]] > preds[order[b]]
+	})
+	return order
+}
+
+// AUC returns weighted area under ROC curve for binary
+// labels, i.e. probability that random positive sample
+// is ranked above random negative one. Ties count as
+// half. Returns NaN if there is one class only
+func AUC(labels, preds, weights []float64) float64 {
+	order := ranked(preds)
+
+	// walk down thresholds, every group of tied
+	// predictions adds trapezoid under the curve
+	var area, tp, fp float64
+	for i := 0; i < len(order); {
+		var dtp, dfp float64
+		j := i
+		for ; j < len(order) && preds[order[j]] == preds[order[i]]; j++ {
+			w := weight(weights, order[j])
+			if labels[order[j]] > 0.5 {
+				dtp += w
+			} else {
+				dfp += w
+			}
+		}
+		area += dfp * (tp + dtp/2)
+		tp += dtp
+		fp += dfp
+		i = j
+	}
+
+	if tp == 0 || fp == 0 {
+		return math.NaN()
+	}
+	return area / (tp * fp)
+}
+
+// PRAUC returns weighted area under precision-recall
+// curve as average precision: sum of precisions at
+// every threshold weighted by increase of recall.
+// Returns NaN if there are no positives
+func PRAUC(labels, preds, weights []float64) float64 {
+	order := ranked(preds)
+
+	var positives float64
+	for i := range labels {
+		if labels[i] > 0.5 {
+			positives += weight(weights, i)
+		}
+	}
+	if positives == 0 {
+		return math.NaN()
+	}
+
+	var area, tp, total float64
+	for i := 0; i < len(order); {
+		var dtp float64
+		j := i
+		for ; j < len(order) && preds[order[j]] == preds[order[i]]; j++ {
+			w := weight(weights, order[j])
+			total += w
+			if labels[order[j]] > 0.5 {
+				dtp += w
+			}
+		}
+		tp += dtp
+		if total > 0 {
+			area += dtp / positives * tp / total
+		}
+		i = j
+	}
+	return area
+}
+
+// Bin is group of samples with close predictions
+// of reliability diagram
+type Bin struct {
+	// predictions of bin are in [Lower, Upper)
+	Lower, Upper float64
+	MeanPred     float64
+	MeanLabel    float64
+	Weight       float64
+	Count        int
+}
+
+// Reliability splits [0, 1] into n equal bins and
+// returns mean prediction and mean label of samples
+// in every bin. Predictions of calibrated model are
+// close to labels in every bin. Prediction 1 belongs
+// to the last bin
+func Reliability(labels, preds, weights []float64, n int) []Bin {
+	bins := make([]Bin, n)
+	for k := range bins {
+		bins[k].Lower = float64(k) / float64(n)
+		bins[k].Upper = float64(k+1) / float64(n)
+	}
+
+	for i, p := range preds {
+		k := int(p * float64(n))
+		if k >= n {
+			k = n - 1
+		}
+		if k < 0 {
+			k = 0
+		}
+		w := weight(weights, i)
+		bins[k].MeanPred += w * p
+		bins[k].MeanLabel += w * labels[i]
+		bins[k].Weight += w
+		bins[k].Count++
+	}
+
+	for k := range bins {
+		if bins[k].Weight > 0 {
+			bins[k].MeanPred /= bins[k].Weight
+			bins[k].MeanLabel /= bins[k].Weight
+		}
+	}
+	return bins
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-12
+}
+
+func TestAUC(t *testing.T) {
+	cases := []struct {
+		labels, preds, weights []float64
+		auc                    float64
+	}{
+		{[]float64{0, 0, 1, 1}, []float64{0.1, 0.4, 0.35, 0.8}, nil, 0.75},
+		{[]float64{1, 0, 1, 0}, []float64{0.9, 0.1, 0.8, 0.2}, nil, 1},
+		{[]float64{1, 0, 1, 0}, []float64{0.5, 0.5, 0.5, 0.5}, nil, 0.5},
+		{[]float64{0, 1, 1}, []float64{0.5, 0.5, 0.7}, nil, 0.75},
+		// weight 2 is the same as repeated sample
+		{[]float64{0, 0, 1, 1}, []float64{0.1, 0.4, 0.35, 0.8}, []float64{1, 2, 1, 1}, 4.0 / 6},
+	}
+	for _, c := range cases {
+		if auc := AUC(c.labels, c.preds, c.weights); !near(auc, c.auc) {
+			t.Errorf("AUC(%v, %v, %v) = %v, expected %v", c.labels, c.preds, c.weights, auc, c.auc)
+		}
+	}
+	if !math.IsNaN(AUC([]float64{1, 1}, []float64{0.2, 0.3}, nil)) {
+		t.Error("AUC of one class is defined")
+	}
+}
+
+func TestPRAUC(t *testing.T) {
+	labels := []float64{1, 0, 1, 0}
+	preds := []float64{0.9, 0.8, 0.7, 0.1}
+	// precision 1 at recall 0.5, 2/3 at recall 1
+	if ap := PRAUC(labels, preds, nil); !near(ap, 0.5*1+0.5*2.0/3) {
+		t.Errorf("PRAUC = %v", ap)
+	}
+	if ap := PRAUC([]float64{1, 0}, []float64{0.9, 0.1}, nil); !near(ap, 1) {
+		t.Errorf("PRAUC of perfect ranking = %v", ap)
+	}
+}
+
+func TestCalibrationMetrics(t *testing.T) {
+	labels := []float64{1, 0, 0, 1}
+	base := []float64{0.5, 0.5, 0.5, 0.5}
+	if ne := NormalizedEntropy(labels, base, nil); !near(ne, 1) {
+		t.Errorf("NE of base rate = %v", ne)
+	}
+	if ne := NormalizedEntropy(labels, []float64{0.9, 0.1, 0.2, 0.8}, nil); ne >= 1 {
+		t.Errorf("NE of good model = %v", ne)
+	}
+	if c := Calibration(labels, []float64{0.6, 0.2, 0.2, 0.6}, nil); !near(c, 0.8) {
+		t.Errorf("Calibration = %v", c)
+	}
+	if c := CalibrationError(labels, []float64{0.6, 0.6, 0.6, 0.6}, nil); !near(c, 0.2) {
+		t.Errorf("CalibrationError = %v", c)
+	}
+	if m, _ := Get("calibration"); !m.LogOnly || !near(m.Eval(labels, base, nil), 1) {
+		t.Errorf("calibration is not registered as log only ratio")
+	}
+	if m, _ := Get("calibration_error"); m.Maximize || m.LogOnly || !near(m.Eval(labels, base, nil), 0) {
+		t.Errorf("calibration error is not registered as distance from 1")
+	}
+
+	bins := Reliability(labels, []float64{0.9, 0.1, 0.3, 1}, []float64{1, 1, 2, 1}, 2)
+	if len(bins) != 2 || bins[0].Count != 2 || bins[1].Count != 2 {
+		t.Fatalf("bins %+v", bins)
+	}
+	if !near(bins[0].MeanPred, (0.1+2*0.3)/3) || !near(bins[0].MeanLabel, 0) ||
+		!near(bins[1].MeanPred, 0.95) || !near(bins[1].MeanLabel, 1) || bins[0].Weight != 3 {
+		t.Errorf("bins %+v", bins)
+	}
+}
+
+func TestRegressionMetrics(t *testing.T) {
+	labels := []float64{1, 2, 4}
+	preds := []float64{2, 2, 1}
+	if v := RMSE(labels, preds, nil); !near(v, math.Sqrt(10.0/3)) {
+		t.Errorf("RMSE = %v", v)
+	}
+	if v := MAE(labels, preds, []float64{1, 1, 2}); !near(v, 7.0/4) {
+		t.Errorf("MAE = %v", v)
+	}
+	if v := PoissonDeviance(labels, labels, nil); !near(v, 0) {
+		t.Errorf("deviance of exact predictions = %v", v)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	for _, name := range Names() {
+		m, err := Get(name)
+		if err != nil || m.Name != name || m.Eval == nil {
+			t.Errorf("metric %s: %+v, %v", name, m, err)
+		}
+	}
+	if m, _ := Get("auc"); !m.Maximize {
+		t.Error("AUC is not maximized")
+	}
+	if _, err := Get("accuracy"); err == nil {
+		t.Error("unknown metric is found")
+	}
+}
@@ -0,0 +1,473 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// Binary dataset layout (little endian). Every section
+// starts at offset divisible by 8, so arrays can be used
+// right from memory mapped file:
+//
+//	header   64 bytes
+//	  magic   [4]byte "FTDS"
+//	  version uint16
+//	  flags   uint16 (binaryFlag, weightedFlag, narrowIndexFlag, narrowValueFlag)
+//	  nrows, ncols, nnz uint64
+//	  meanTarget, weightsSum float64
+//	  namesSize uint64
+//	  reserved uint64
+//	ia       (nrows+1) * uint64
+//	ja       nnz * uint64, or nnz * uint32 if narrowIndexFlag
+//	dat      nnz * float64, or nnz * float32 if narrowValueFlag,
+//	         absent for binary matrix
+//	labels   nrows * float64
+//	weights  nrows * float64, absent if not weighted
+//	names    namesSize bytes, '\n' separated
+//
+// Narrow sections are padded with zeros to 8 bytes.
+// Version 1 files have no narrow sections
+const (
+	datasetVersion    uint16 = 2
+	datasetHeaderSize        = 64
+
+	binaryFlag      uint16 = 1 << 0
+	weightedFlag    uint16 = 1 << 1
+	narrowIndexFlag uint16 = 1 << 2
+	narrowValueFlag uint16 = 1 << 3
+)
+
+var datasetMagic = [4]byte{'F', 'T', 'D', 'S'}
+
+// ErrBadDataset is returned when file is not a
+// binary dataset or its content is malformed
+var ErrBadDataset = errors.New("utils: malformed binary dataset")
+
+type datasetHeader struct {
+	Magic      [4]byte
+	Version    uint16
+	Flags      uint16
+	NRows      uint64
+	NCols      uint64
+	Nnz        uint64
+	MeanTarget float64
+	WeightsSum float64
+	NamesSize  uint64
+	Reserved   uint64
+}
+
+// SaveBinary writes dataset to file in binary format,
+// which is much faster to load than text formats
+func (d *Dataset) SaveBinary(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := d.writeBinary(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadBinary reads dataset written by SaveBinary
+func (d *Dataset) LoadBinary(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := d.readBinary(file, uint64(info.Size())); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (d *Dataset) writeBinary(out io.Writer) error {
+	// views are written as datasets of their rows
+	d = d.materialize()
+	w := bufio.NewWriterSize(out, 1<<20)
+	csr := d.data
+	names := strings.Join(d.featureNames, "\n")
+
+	header := datasetHeader{
+		Magic:      datasetMagic,
+		Version:    datasetVersion,
+		NRows:      csr.nrows,
+		NCols:      csr.ncols,
+		Nnz:        csr.nnz,
+		MeanTarget: d.meanTarget,
+		WeightsSum: d.weightsSum,
+		NamesSize:  uint64(len(names)),
+	}
+	if csr.isBinary {
+		header.Flags |= binaryFlag
+	}
+	if d.isWeighted {
+		header.Flags |= weightedFlag
+	}
+	if csr.ja32 != nil {
+		header.Flags |= narrowIndexFlag
+	}
+	if csr.dat32 != nil {
+		header.Flags |= narrowValueFlag
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if err := writeUint64s(w, csr.ia); err != nil {
+		return err
+	}
+	if csr.ja32 != nil {
+		if err := writeUint32s(w, csr.ja32); err != nil {
+			return err
+		}
+	} else if err := writeUint64s(w, csr.ja); err != nil {
+		return err
+	}
+	if csr.dat32 != nil {
+		if err := writeFloat32s(w, csr.dat32); err != nil {
+			return err
+		}
+	} else if !csr.isBinary {
+		if err := writeFloat64s(w, csr.dat); err != nil {
+			return err
+		}
+	}
+	if err := writeFloat64s(w, d.labels); err != nil {
+		return err
+	}
+	if d.isWeighted {
+		if err := writeFloat64s(w, d.sampleWeights); err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteString(names); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// readBinary reads dataset from file of given size
+func (d *Dataset) readBinary(in io.Reader, size uint64) error {
+	r := bufio.NewReaderSize(in, 1<<20)
+
+	var header datasetHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadDataset, err)
+	}
+	if err := header.validate(); err != nil {
+		return err
+	}
+	// arrays are allocated by header counts
+	if err := header.checkSize(size); err != nil {
+		return err
+	}
+
+	isBinary := header.Flags&binaryFlag != 0
+	csr := MakeCSR(isBinary)
+	csr.ia = make([]uint64, header.NRows+1)
+	if err := readUint64s(r, csr.ia); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadDataset, err)
+	}
+	var err error
+	if header.Flags&narrowIndexFlag != 0 {
+		csr.ja32 = make([]uint32, header.Nnz)
+		err = readUint32s(r, csr.ja32)
+	} else {
+		csr.ja = make([]uint64, header.Nnz)
+		err = readUint64s(r, csr.ja)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadDataset, err)
+	}
+	if !isBinary {
+		if header.Flags&narrowValueFlag != 0 {
+			csr.dat32 = make([]float32, header.Nnz)
+			err = readFloat32s(r, csr.dat32)
+		} else {
+			csr.dat = make([]float64, header.Nnz)
+			err = readFloat64s(r, csr.dat)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBadDataset, err)
+		}
+	}
+	if err := validateRowIndex(csr.ia, header.Nnz); err != nil {
+		return err
+	}
+	if err := validateColumns(csr, header.NCols); err != nil {
+		return err
+	}
+	csr.setShape(header.NRows, header.NCols, header.Nnz)
+
+	labels := make([]float64, header.NRows)
+	if err := readFloat64s(r, labels); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadDataset, err)
+	}
+
+	isWeighted := header.Flags&weightedFlag != 0
+	var weights []float64
+	if isWeighted {
+		weights = make([]float64, header.NRows)
+		if err := readFloat64s(r, weights); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadDataset, err)
+		}
+	}
+
+	var names []string
+	if header.NamesSize > 0 {
+		buf := make([]byte, header.NamesSize)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadDataset, err)
+		}
+		names = strings.Split(string(buf), "\n")
+	}
+
+	d.data = csr
+	d.labels = labels
+	d.isWeighted = isWeighted
+	d.sampleWeights = weights
+	d.weightsSum = header.WeightsSum
+	d.meanTarget = header.MeanTarget
+	d.featureNames = names
+	return nil
+}
+
+func (h *datasetHeader) validate() error {
+	if h.Magic != datasetMagic {
+		return ErrBadDataset
+	}
+	if h.Version < 1 || h.Version > datasetVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrBadDataset, h.Version)
+	}
+	if h.Version == 1 && h.Flags&(narrowIndexFlag|narrowValueFlag) != 0 {
+		return fmt.Errorf("%w: unexpected flags %#x", ErrBadDataset, h.Flags)
+	}
+	return nil
+}
+
+// widths returns width of column index and
+// value elements in bytes
+func (h *datasetHeader) widths() (uint64, uint64) {
+	indexWidth, valueWidth := uint64(8), uint64(8)
+	if h.Flags&narrowIndexFlag != 0 {
+		indexWidth = 4
+	}
+	if h.Flags&narrowValueFlag != 0 {
+		valueWidth = 4
+	}
+	return indexWidth, valueWidth
+}
+
+// checkSize checks that file of n bytes matches
+// header. Counts are bounded by n first, so that
+// sum of sections can not overflow
+func (h *datasetHeader) checkSize(n uint64) error {
+	if h.NRows > n/8 || h.Nnz > n/4 || h.NamesSize > n {
+		return fmt.Errorf("%w: %d rows and %d values in %d bytes",
+			ErrBadDataset, h.NRows, h.Nnz, n)
+	}
+
+	indexWidth, valueWidth := h.widths()
+	size := 8*(h.NRows+1) + sectionSize(h.Nnz, indexWidth) + 8*h.NRows
+	if h.Flags&binaryFlag == 0 {
+		size += sectionSize(h.Nnz, valueWidth)
+	}
+	if h.Flags&weightedFlag != 0 {
+		size += 8 * h.NRows
+	}
+	size += datasetHeaderSize + h.NamesSize
+	if n != size {
+		return fmt.Errorf("%w: file size %d, expected %d", ErrBadDataset, n, size)
+	}
+	return nil
+}
+
+// sectionSize returns size of section of n elements
+// of given width including padding
+func sectionSize(n, width uint64) uint64 {
+	return (n*width + 7) &^ 7
+}
+
+// validateRowIndex checks that rows of csr
+// matrix are within its nnz elements
+func validateRowIndex(ia []uint64, nnz uint64) error {
+	if ia[0] != 0 || ia[len(ia)-1] != nnz {
+		return fmt.Errorf("%w: row index does not match nnz", ErrBadDataset)
+	}
+	for i := 1; i < len(ia); i++ {
+		if ia[i] < ia[i-1] {
+			return fmt.Errorf("%w: row index is not sorted", ErrBadDataset)
+		}
+	}
+	return nil
+}
+
+// validateColumns checks that column indexes
+// of csr matrix are below ncols
+func validateColumns(csr *CSRMatrix, ncols uint64) error {
+	for _, col := range csr.ja {
+		if col >= ncols {
+			return fmt.Errorf("%w: column %d of %d", ErrBadDataset, col, ncols)
+		}
+	}
+	for _, col := range csr.ja32 {
+		if uint64(col) >= ncols {
+			return fmt.Errorf("%w: column %d of %d", ErrBadDataset, col, ncols)
+		}
+	}
+	return nil
+}
+
+// setShape sets metadata of matrix built from arrays
+func (csr *CSRMatrix) setShape(nrows, ncols, nnz uint64) {
+	csr.nrows = nrows
+	csr.ncols = ncols
+	csr.nnz = nnz
+	if nrows > 0 {
+		csr.n = nrows - 1
+	}
+	if ncols > 0 {
+		csr.m = ncols - 1
+	}
+}
+
+// isLittleEndian reports byte order of the host. Arrays
+// are copied as raw memory on little endian hosts
+var isLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// rawBytes returns memory of n elements of
+// given width at ptr
+func rawBytes(ptr unsafe.Pointer, n, width int) []byte {
+	if n == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(ptr), width*n)
+}
+
+// padding fills narrow section of n elements up to 8 bytes
+var padding [8]byte
+
+func writePadding(w io.Writer, n int) error {
+	_, err := w.Write(padding[:sectionSize(uint64(n), 4)-4*uint64(n)])
+	return err
+}
+
+func readPadding(r io.Reader, n int) error {
+	var pad [8]byte
+	_, err := io.ReadFull(r, pad[:sectionSize(uint64(n), 4)-4*uint64(n)])
+	return err
+}
+
+func writeUint64s(w io.Writer, s []uint64) error {
+	if !isLittleEndian {
+		return binary.Write(w, binary.LittleEndian, s)
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	_, err := w.Write(rawBytes(unsafe.Pointer(&s[0]), len(s), 8))
+	return err
+}
+
+func writeFloat64s(w io.Writer, s []float64) error {
+	if !isLittleEndian {
+		return binary.Write(w, binary.LittleEndian, s)
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	_, err := w.Write(rawBytes(unsafe.Pointer(&s[0]), len(s), 8))
+	return err
+}
+
+func readUint64s(r io.Reader, s []uint64) error {
+	if !isLittleEndian {
+		return binary.Read(r, binary.LittleEndian, s)
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	_, err := io.ReadFull(r, rawBytes(unsafe.Pointer(&s[0]), len(s), 8))
+	return err
+}
+
+func readFloat64s(r io.Reader, s []float64) error {
+	if !isLittleEndian {
+		return binary.Read(r, binary.LittleEndian, s)
+	}
+	if len(s) == 0 {
+		return nil
+	}
+	_, err := io.ReadFull(r, rawBytes(unsafe.Pointer(&s[0]), len(s), 8))
+	return err
+}
+
+func writeUint32s(w io.Writer, s []uint32) error {
+	var err error
+	if !isLittleEndian {
+		err = binary.Write(w, binary.LittleEndian, s)
+	} else if len(s) > 0 {
+		_, err = w.Write(rawBytes(unsafe.Pointer(&s[0]), len(s), 4))
+	}
+	if err != nil {
+		return err
+	}
+	return writePadding(w, len(s))
+}
+
+func writeFloat32s(w io.Writer, s []float32) error {
+	var err error
+	if !isLittleEndian {
+		err = binary.Write(w, binary.LittleEndian, s)
+	} else if len(s) > 0 {
+		_, err = w.Write(rawBytes(unsafe.Pointer(&s[0]), len(s), 4))
+	}
+	if err != nil {
+		return err
+	}
+	return writePadding(w, len(s))
+}
+
+func readUint32s(r io.Reader, s []uint32) error {
+	var err error
+	if !isLittleEndian {
+		err = binary.Read(r, binary.LittleEndian, s)
+	} else if len(s) > 0 {
+		_, err = io.ReadFull(r, rawBytes(unsafe.Pointer(&s[0]), len(s), 4))
+	}
+	if err != nil {
+		return err
+	}
+	return readPadding(r, len(s))
+}
+
+func readFloat32s(r io.Reader, s []float32) error {
+	var err error
+	if !isLittleEndian {
+		err = binary.Read(r, binary.LittleEndian, s)
+	} else if len(s) > 0 {
+		_, err = io.ReadFull(r, rawBytes(unsafe.Pointer(&s[0]), len(s), 4))
+	}
+	if err != nil {
+		return err
+	}
+	return readPadding(r, len(s))
+}
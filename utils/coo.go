@@ -58,16 +58,37 @@ func (mat *COOMatrix) GetShape() map[string]uint64 {
 	return map[string]uint64{"rows": mat.n + 1, "cols": mat.m + 1}
 }
 
-// ShuffleRows shuffles rows of matrix
-func (mat *COOMatrix) ShuffleRows() {
-	rand.Seed(42) // time.Now().UnixNano()
-	rand.Shuffle(len(mat.row)-1, func(i, j int) {
-		mat.row[i], mat.row[j] = mat.row[j], mat.row[i]
-		mat.col[i], mat.col[j] = mat.col[j], mat.col[i]
-		if !mat.isBinary {
-			mat.dat[i], mat.dat[j] = mat.dat[j], mat.dat[i]
-		}
+// Reshape grows shape of matrix to at least
+// nrows x ncols, e.g. to keep empty trailing rows
+func (mat *COOMatrix) Reshape(nrows, ncols uint64) {
+	if nrows > mat.nrows {
+		mat.nrows = nrows
+		mat.n = nrows - 1
+	}
+	if ncols > mat.ncols {
+		mat.ncols = ncols
+		mat.m = ncols - 1
+	}
+}
+
+// ShuffleRows moves rows of matrix to random positions
+// given by seed, keeping every row intact. Returns
+// permutation: row i is moved to perm[i], so vectors
+// along rows, e.g. labels, can be permuted the same way
+func (mat *COOMatrix) ShuffleRows(seed int64) []uint64 {
+	perm := make([]uint64, mat.nrows)
+	for i := range perm {
+		perm[i] = uint64(i)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(perm), func(i, j int) {
+		perm[i], perm[j] = perm[j], perm[i]
 	})
+
+	for i := range mat.row {
+		mat.row[i] = perm[mat.row[i]]
+	}
+	return perm
 }
 
 // MakeCOO creates empty COO matrix
@@ -1,12 +1,37 @@
 package utils
 
-import "runtime"
+import (
+	"math"
+	"runtime"
+	"sync"
+)
 
-// CSRMatrix is compressed sparse row matrix
+// Storage selects width of arrays of CSR matrix
+type Storage uint8
+
+const (
+	// AutoStorage keeps column indices in uint32 if number
+	// of columns allows, values in float32 if all of them
+	// are exact in float32 and drops values if all are 1
+	AutoStorage Storage = iota
+	// WideStorage keeps uint64 indices and float64 values
+	WideStorage
+	// CompactStorage is AutoStorage, but values are always
+	// kept in float32 even if they lose precision
+	CompactStorage
+)
+
+// CSRMatrix is compressed sparse row matrix. Exactly one
+// of ja, ja32 holds column indices, values are held by
+// dat or dat32 or none of them for binary matrix
 type CSRMatrix struct {
-	dat []float64
-	ia  []uint64
-	ja  []uint64
+	dat   []float64
+	dat32 []float32
+	ia    []uint64
+	ja    []uint64
+	ja32  []uint32
+
+	storage Storage
 
 	n        uint64
 	m        uint64
@@ -18,16 +43,37 @@ type CSRMatrix struct {
 
 	isCached bool
 	cache    []Sample
+
+	// memory mapped file backing arrays, see MapBinary
+	mapping []byte
 }
 
 // FromCOO builds sparse row matrix through
-// iteration over COO matrix
+// iteration over COO matrix. Width of arrays
+// is chosen according to storage of matrix
 func (csr *CSRMatrix) FromCOO(coo *COOMatrix) {
+	csr.isBinary = csr.isBinary || coo.isBinary
+	if !csr.isBinary && csr.storage == AutoStorage && allOnes(coo.dat) {
+		csr.isBinary = true
+	}
+	narrowIndex := csr.storage != WideStorage && coo.ncols <= math.MaxUint32+1
+	narrowValue := csr.storage == CompactStorage ||
+		(csr.storage == AutoStorage && exactInFloat32(coo.dat))
 
 	csr.ia = make([]uint64, coo.nrows+1)
-	csr.ja = make([]uint64, coo.nnz)
+	csr.ja, csr.ja32 = nil, nil
+	csr.dat, csr.dat32 = nil, nil
+	if narrowIndex {
+		csr.ja32 = make([]uint32, coo.nnz)
+	} else {
+		csr.ja = make([]uint64, coo.nnz)
+	}
 	if !csr.isBinary {
-		csr.dat = make([]float64, coo.nnz)
+		if narrowValue {
+			csr.dat32 = make([]float32, coo.nnz)
+		} else {
+			csr.dat = make([]float64, coo.nnz)
+		}
 	}
 
 	// compute number of non-zero entries per row
@@ -54,8 +100,14 @@ func (csr *CSRMatrix) FromCOO(coo *COOMatrix) {
 		row := coo.row[i]
 		dest := csr.ia[row]
 
-		csr.ja[dest] = coo.col[i]
-		if !csr.isBinary {
+		if narrowIndex {
+			csr.ja32[dest] = uint32(coo.col[i])
+		} else {
+			csr.ja[dest] = coo.col[i]
+		}
+		if csr.dat32 != nil {
+			csr.dat32[dest] = float32(coo.dat[i])
+		} else if csr.dat != nil {
 			csr.dat[dest] = coo.dat[i]
 		}
 
@@ -78,49 +130,126 @@ func (csr *CSRMatrix) FromCOO(coo *COOMatrix) {
 	csr.ncols = coo.ncols
 }
 
+// take returns matrix of given rows of csr,
+// arrays are of the same width as in csr
+func (csr *CSRMatrix) take(rows []uint64) *CSRMatrix {
+	result := MakeCSR(csr.isBinary)
+	result.storage = csr.storage
+	result.ia = make([]uint64, len(rows)+1)
+	for i, row := range rows {
+		result.ia[i+1] = result.ia[i] + csr.ia[row+1] - csr.ia[row]
+	}
+	nnz := result.ia[len(rows)]
+
+	if csr.ja32 != nil {
+		result.ja32 = make([]uint32, 0, nnz)
+	} else {
+		result.ja = make([]uint64, 0, nnz)
+	}
+	if csr.dat32 != nil {
+		result.dat32 = make([]float32, 0, nnz)
+	} else if csr.dat != nil {
+		result.dat = make([]float64, 0, nnz)
+	}
+	for _, row := range rows {
+		l, r := csr.ia[row], csr.ia[row+1]
+		if csr.ja32 != nil {
+			result.ja32 = append(result.ja32, csr.ja32[l:r]...)
+		} else {
+			result.ja = append(result.ja, csr.ja[l:r]...)
+		}
+		if csr.dat32 != nil {
+			result.dat32 = append(result.dat32, csr.dat32[l:r]...)
+		} else if csr.dat != nil {
+			result.dat = append(result.dat, csr.dat[l:r]...)
+		}
+	}
+
+	result.setShape(uint64(len(rows)), csr.ncols, nnz)
+	return result
+}
+
+// SetStorage sets width of arrays built by FromCOO
+func (csr *CSRMatrix) SetStorage(storage Storage) {
+	csr.storage = storage
+}
+
+// allOnes reports whether all values are 1
+func allOnes(dat []float64) bool {
+	for _, v := range dat {
+		if v != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// exactInFloat32 reports whether all values
+// survive conversion to float32
+func exactInFloat32(dat []float64) bool {
+	for _, v := range dat {
+		if float64(float32(v)) != v {
+			return false
+		}
+	}
+	return true
+}
+
 // BuildRow constructs map from i-th row data
 // like this: {col1: val1, col2:val2, ...}
 // where col's are the column indexes and
 // val's are the values at that columns
 func (csr *CSRMatrix) BuildRow(ith uint64) Sample {
-	l := csr.ia[ith]
-	r := csr.ia[ith+1]
-	cols := csr.ja[l:r]
-	var vals []float64
-	if !csr.isBinary {
-		vals = csr.dat[l:r]
-	}
-
-	size := len(cols)
+	view := csr.View(ith)
+	size := view.Len()
 	result := make(Sample, size)
 	for i := 0; i < size; i++ {
-		if !csr.isBinary {
-			result[i] = Feature{cols[i], vals[i]}
-		} else {
-			result[i] = Feature{cols[i], 1.0}
-		}
+		result[i] = view.At(i)
 	}
 
 	return result
 }
 
+// RowInto appends elements of ith row to buf and returns
+// the extended buffer. Passing buf[:0] of previous call
+// reuses its memory, so no allocations are made once
+// buffer is large enough for the longest row
+func (csr *CSRMatrix) RowInto(ith uint64, buf Sample) Sample {
+	view := csr.View(ith)
+	for i := 0; i < view.Len(); i++ {
+		buf = append(buf, view.At(i))
+	}
+	return buf
+}
+
+// CacheRows builds every row as Sample, so that
+// GetRow returns them without allocation
 func (csr *CSRMatrix) CacheRows() {
 	cache := make([]Sample, csr.nrows)
 	nworkers := runtime.NumCPU()
-	chunksize := int(csr.nrows) / nworkers
+	chunksize := (int(csr.nrows) + nworkers - 1) / nworkers
+	var wg sync.WaitGroup
 	for i := 0; i < nworkers; i++ {
 		start := i * chunksize
 		end := start + chunksize
+		if end > int(csr.nrows) {
+			end = int(csr.nrows)
+		}
+		wg.Add(1)
 		go func() {
 			for j := start; j < end; j++ {
 				cache[j] = csr.BuildRow(uint64(j))
 			}
+			wg.Done()
 		}()
 	}
+	wg.Wait()
 	csr.isCached = true
 	csr.cache = cache
 }
 
+// GetRow returns ith row, cached one if rows are
+// cached, otherwise it allocates new row by BuildRow
 func (csr *CSRMatrix) GetRow(ith uint64) Sample {
 	if !csr.isCached {
 		return csr.BuildRow(ith)
@@ -128,6 +257,57 @@ func (csr *CSRMatrix) GetRow(ith uint64) Sample {
 	return csr.cache[ith]
 }
 
+// RowView is read only window into a row of matrix.
+// It refers to matrix arrays, so no memory is allocated
+type RowView struct {
+	cols   []uint64
+	cols32 []uint32
+	vals   []float64
+	vals32 []float32
+}
+
+// View returns window into ith row
+func (csr *CSRMatrix) View(ith uint64) RowView {
+	l := csr.ia[ith]
+	r := csr.ia[ith+1]
+	var view RowView
+	if csr.ja32 != nil {
+		view.cols32 = csr.ja32[l:r]
+	} else if csr.ja != nil {
+		view.cols = csr.ja[l:r]
+	}
+	if csr.dat32 != nil {
+		view.vals32 = csr.dat32[l:r]
+	} else if csr.dat != nil {
+		view.vals = csr.dat[l:r]
+	}
+	return view
+}
+
+// Len returns number of stored elements of row
+func (v RowView) Len() int {
+	return len(v.cols) + len(v.cols32)
+}
+
+// At returns ith stored element of row
+func (v RowView) At(i int) Feature {
+	var f Feature
+	if v.cols32 != nil {
+		f.Key = uint64(v.cols32[i])
+	} else {
+		f.Key = v.cols[i]
+	}
+	switch {
+	case v.vals32 != nil:
+		f.Value = float64(v.vals32[i])
+	case v.vals != nil:
+		f.Value = v.vals[i]
+	default:
+		f.Value = 1.0
+	}
+	return f
+}
+
 // MakeCSR creates empty csr matrix
 func MakeCSR(binary bool) *CSRMatrix {
 	return &CSRMatrix{isBinary: binary}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// CSVSpec describes how columns of csv file
+// are turned into labels, weights and features
+type CSVSpec struct {
+	// Label is name of target column
+	Label string
+	// Weight is name of sample weight column, optional
+	Weight string
+	// Numeric columns are used as real valued features
+	Numeric []string
+	// Categorical columns are one-hot encoded, every
+	// seen value "column=value" gets its own index
+	Categorical []string
+	// HashBits if nonzero makes categorical values hashed
+	// into 2^HashBits indexes instead of vocabulary,
+	// names of hashed values are not kept
+	HashBits uint
+	// Comma is field separator, ',' by default
+	Comma rune
+}
+
+// CSVEncoder maps csv records to sparse rows. Indexes
+// of numeric columns go first, then indexes of
+// categorical values. Vocabulary grows as new
+// values are met
+type CSVEncoder struct {
+	spec CSVSpec
+
+	labelCol   int
+	weightCol  int
+	numericCol []int
+	catCol     []int
+
+	vocab  map[string]uint64
+	names  []string
+	fields []int32
+	// field of first value met in every used hashed
+	// column, filled lazily as values are hashed
+	hashed map[uint64]int32
+
+	// values of numeric columns of current record
+	values []float64
+}
+
+// MakeCSVEncoder creates encoder with empty vocabulary
+func MakeCSVEncoder(spec CSVSpec) *CSVEncoder {
+	enc := &CSVEncoder{
+		spec:   spec,
+		vocab:  make(map[string]uint64),
+		names:  append([]string{}, spec.Numeric...),
+		fields: make([]int32, len(spec.Numeric)),
+	}
+	for i := range enc.fields {
+		enc.fields[i] = int32(i)
+	}
+	if spec.HashBits > 0 {
+		enc.hashed = make(map[uint64]int32)
+	}
+	return enc
+}
+
+// NCols returns number of columns known to encoder,
+// all 2^HashBits hashed columns count if values
+// are hashed
+func (enc *CSVEncoder) NCols() uint64 {
+	if enc.hashed != nil {
+		return uint64(len(enc.names)) + 1<<enc.spec.HashBits
+	}
+	return uint64(len(enc.names))
+}
+
+// FeatureNames returns name of every column, or nil
+// if values are hashed, as hashed column may hold
+// many colliding values
+func (enc *CSVEncoder) FeatureNames() []string {
+	if enc.hashed != nil {
+		return nil
+	}
+	return append([]string{}, enc.names...)
+}
+
+// Fields returns map of column to its source csv
+// column: numeric columns first, then categorical
+// ones in order of spec. Hashed column belongs to
+// the field of the first value met in it, unused
+// ones to none
+func (enc *CSVEncoder) Fields() *FieldMap {
+	if enc.hashed == nil {
+		return MakeFieldTable(enc.fields)
+	}
+	fields := make([]int32, enc.NCols())
+	copy(fields, enc.fields)
+	for i := len(enc.fields); i < len(fields); i++ {
+		fields[i] = -1
+	}
+	for idx, field := range enc.hashed {
+		fields[idx] = field
+	}
+	return &FieldMap{table: fields}
+}
+
+// bind finds positions of spec columns in header
+func (enc *CSVEncoder) bind(header []string) error {
+	position := make(map[string]int, len(header))
+	for i, name := range header {
+		position[name] = i
+	}
+	find := func(name string) (int, error) {
+		i, ok := position[name]
+		if !ok {
+			return -1, fmt.Errorf("csv: no column %q in header", name)
+		}
+		return i, nil
+	}
+
+	var err error
+	if enc.labelCol, err = find(enc.spec.Label); err != nil {
+		return err
+	}
+	enc.weightCol = -1
+	if enc.spec.Weight != "" {
+		if enc.weightCol, err = find(enc.spec.Weight); err != nil {
+			return err
+		}
+	}
+
+	enc.numericCol = make([]int, len(enc.spec.Numeric))
+	for i, name := range enc.spec.Numeric {
+		if enc.numericCol[i], err = find(name); err != nil {
+			return err
+		}
+	}
+	enc.catCol = make([]int, len(enc.spec.Categorical))
+	for i, name := range enc.spec.Categorical {
+		if enc.catCol[i], err = find(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode writes features of record into row of
+// matrix, returns label and sample weight
+func (enc *CSVEncoder) encode(record []string, row uint64,
+	matrix *COOMatrix) (float64, float64, error) {
+
+	label, err := strconv.ParseFloat(record[enc.labelCol], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	weight := 1.0
+	if enc.weightCol >= 0 {
+		weight, err = strconv.ParseFloat(record[enc.weightCol], 64)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// values are checked before any of them is set,
+	// so malformed record leaves matrix intact
+	enc.values = enc.values[:0]
+	for _, col := range enc.numericCol {
+		val := 0.0
+		if record[col] != "" {
+			val, err = strconv.ParseFloat(record[col], 64)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		enc.values = append(enc.values, val)
+	}
+	for i, col := range enc.numericCol {
+		if record[col] != "" {
+			matrix.Set(row, uint64(i), enc.values[i])
+		}
+	}
+
+	for i, col := range enc.catCol {
+		if record[col] == "" {
+			continue
+		}
+		name := enc.spec.Categorical[i] + "=" + record[col]
+		matrix.Set(row, enc.index(name, len(enc.spec.Numeric)+i), 1)
+	}
+
+	return label, weight, nil
+}
+
+// index returns column index of categorical value
+func (enc *CSVEncoder) index(name string, field int) uint64 {
+	if enc.spec.HashBits > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(name))
+		idx := uint64(len(enc.spec.Numeric)) + h.Sum64()&(1<<enc.spec.HashBits-1)
+		if _, ok := enc.hashed[idx]; !ok {
+			enc.hashed[idx] = int32(field)
+		}
+		return idx
+	}
+
+	idx, ok := enc.vocab[name]
+	if !ok {
+		idx = uint64(len(enc.names))
+		enc.names = append(enc.names, name)
+		enc.fields = append(enc.fields, int32(field))
+		enc.vocab[name] = idx
+	}
+	return idx
+}
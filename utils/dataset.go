@@ -2,6 +2,9 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -22,32 +25,72 @@ type Sample []Feature
 // providing meta information
 type Dataset struct {
 	data          *CSRMatrix
-	labels        []uint8
+	labels        []float64
 	isWeighted    bool
 	meanTarget    float64
 	weightsSum    float64
 	sampleWeights []float64
 	featureNames  []string
+	storage       Storage
+
+	// rows of view into another dataset, indexes of
+	// data and labels. Nil for dataset owning its rows
+	rows []uint64
+	nnz  uint64
+
+	lenient bool
+	// positions of malformed records skipped by the
+	// last loader among all records of its file
+	skipped []uint64
+}
+
+// SetStorage sets width of arrays of matrices built by
+// loaders, AutoStorage by default. Call before loading
+func (d *Dataset) SetStorage(storage Storage) {
+	d.storage = storage
 }
 
 // Shape returns tuple with sizes for each dimension
 func (d *Dataset) Shape() (uint64, uint64) {
-	return d.data.nrows, d.data.ncols
+	return d.NRows(), d.NCols()
 }
 
 // Sparcity computes ratio of nonzero elements to all elements
 func (d *Dataset) Sparcity() float64 {
-	return float64(d.data.nnz) / float64(d.data.nrows*d.data.ncols)
+	return float64(d.Nnz()) / float64(d.NRows()*d.NCols())
 }
 
-// Row returns elements of ith row of dataset in sparse format
+// Row returns elements of ith row of dataset in sparse
+// format. Unless rows are cached by CacheRows, every
+// call allocates new row, so hot loops should use
+// View or RowInto instead
 func (d *Dataset) Row(ith uint64) Sample {
-	return d.data.GetRow(ith)
+	return d.data.GetRow(d.index(ith))
+}
+
+// CacheRows builds every row of dataset once, so that
+// Row does not allocate. Cache takes 16 bytes per
+// stored value on top of the matrix, training and
+// prediction do not use it
+func (d *Dataset) CacheRows() {
+	d.data.CacheRows()
+}
+
+// RowInto appends elements of ith row to buf, see
+// CSRMatrix.RowInto. Unlike Row it does not allocate
+func (d *Dataset) RowInto(ith uint64, buf Sample) Sample {
+	return d.data.RowInto(d.index(ith), buf)
+}
+
+// View returns elements of ith row as read only window
+// into dataset arrays, without memory allocation
+func (d *Dataset) View(ith uint64) RowView {
+	return d.data.View(d.index(ith))
 }
 
 // Label returns ith element of label vector
-func (d *Dataset) Label(ith uint64) uint8 {
-	return d.labels[ith]
+func (d *Dataset) Label(ith uint64) float64 {
+	return d.labels[d.index(ith)]
 }
 
 // SampleWeight returns ith element of sample weight vector
@@ -55,7 +98,81 @@ func (d *Dataset) SampleWeight(ith uint64) float64 {
 	if !d.isWeighted {
 		return 1.0
 	}
-	return d.sampleWeights[ith]
+	return d.sampleWeights[d.index(ith)]
+}
+
+// Shuffle returns view of dataset with rows in random
+// order given by seed. Rows are kept intact and view
+// shares memory with d, so it is cheap to make a new
+// permutation every epoch
+func (d *Dataset) Shuffle(seed int64) *Dataset {
+	return d.subset(d.permutation(seed))
+}
+
+// index returns row of underlying arrays for ith row
+func (d *Dataset) index(ith uint64) uint64 {
+	if d.rows != nil {
+		return d.rows[ith]
+	}
+	return ith
+}
+
+// subset returns view of given rows of d. Rows are
+// overwritten by indexes of underlying arrays
+func (d *Dataset) subset(rows []uint64) *Dataset {
+	if rows == nil {
+		rows = []uint64{}
+	}
+	view := &Dataset{
+		data:          d.data,
+		labels:        d.labels,
+		isWeighted:    d.isWeighted,
+		sampleWeights: d.sampleWeights,
+		featureNames:  d.featureNames,
+		storage:       d.storage,
+	}
+
+	var sumTarget float64
+	for i, row := range rows {
+		idx := d.index(row)
+		rows[i] = idx
+		view.nnz += d.data.ia[idx+1] - d.data.ia[idx]
+		sumTarget += d.labels[idx]
+		if d.isWeighted {
+			view.weightsSum += d.sampleWeights[idx]
+		}
+	}
+	if len(rows) > 0 {
+		view.meanTarget = sumTarget / float64(len(rows))
+	}
+	view.rows = rows
+	return view
+}
+
+// materialize returns dataset owning copy of rows of view
+func (d *Dataset) materialize() *Dataset {
+	if d.rows == nil {
+		return d
+	}
+	m := &Dataset{
+		data:         d.data.take(d.rows),
+		labels:       make([]float64, len(d.rows)),
+		isWeighted:   d.isWeighted,
+		meanTarget:   d.meanTarget,
+		weightsSum:   d.weightsSum,
+		featureNames: d.featureNames,
+		storage:      d.storage,
+	}
+	if d.isWeighted {
+		m.sampleWeights = make([]float64, len(d.rows))
+	}
+	for i, idx := range d.rows {
+		m.labels[i] = d.labels[idx]
+		if d.isWeighted {
+			m.sampleWeights[i] = d.sampleWeights[idx]
+		}
+	}
+	return m
 }
 
 // WeightsSum return sum of weights of sample if dataset is weighted
@@ -72,13 +189,25 @@ func (d *Dataset) NameOfCol(ith uint64) string {
 	return d.featureNames[ith]
 }
 
+// FeatureNames returns names of columns or nil
+// if they were not loaded
+func (d *Dataset) FeatureNames() []string {
+	return d.featureNames
+}
+
 // Nnz returns numer of stored values
 func (d *Dataset) Nnz() uint64 {
+	if d.rows != nil {
+		return d.nnz
+	}
 	return d.data.nnz
 }
 
 // NRows return number of stored rows
 func (d *Dataset) NRows() uint64 {
+	if d.rows != nil {
+		return uint64(len(d.rows))
+	}
 	return d.data.nrows
 }
 
@@ -87,139 +216,399 @@ func (d *Dataset) NCols() uint64 {
 	return d.data.ncols
 }
 
-// MeanTarget return average probability
-// of outcome for dataset
+// MeanTarget return average target value,
+// i.e. probability of outcome for binary labels
 func (d *Dataset) MeanTarget() float64 {
 	return d.meanTarget
 }
 
-// FromSVMFile parses input file in libsvm format
-// simutaniously updating COO matrix. Finally compresses
-// COO matrix to CSR format.
-func (d *Dataset) FromSVMFile(path string,
-	maxrows int32, isBinary bool) {
+// ParseError is malformed line of input file
+type ParseError struct {
+	Path string
+	// Line is number of line, starting from 1
+	Line int
+	Err  error
+}
 
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// SetLenient makes loaders skip malformed lines instead
+// of failing with ParseError. Skipped lines are counted
+// by Skipped. Lines of weights file are never skipped,
+// they must match either rows or all lines of data
+// file, then weights of skipped lines are dropped.
+// Call before loading
+func (d *Dataset) SetLenient(lenient bool) {
+	d.lenient = lenient
+}
+
+// Skipped returns number of malformed lines
+// skipped by the last loader in lenient mode
+func (d *Dataset) Skipped() uint64 {
+	return uint64(len(d.skipped))
+}
+
+// malformed returns ParseError for bad line, or
+// records line and returns nil in lenient mode. Row
+// is number of rows loaded before the line
+func (d *Dataset) malformed(path string, line int, row uint64, err error) error {
+	if d.lenient {
+		d.skipped = append(d.skipped, row+uint64(len(d.skipped)))
+		return nil
+	}
+	return &ParseError{path, line, err}
+}
+
+// eachLine calls f for every line of file with its
+// number, starting from 1, until f returns false or
+// error. Line is valid until next call
+func eachLine(path string, f func(num int, line []byte) (bool, error)) error {
 	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	num := 0
+	for scanner.Scan() {
+		num++
+		more, err := f(num, bytes.TrimSpace(scanner.Bytes()))
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s:%d: %w", path, num+1, err)
+	}
+	return nil
+}
+
+// FromSVMFile parses input file in libsvm format
+// simutaniously updating COO matrix. Finally compresses
+// COO matrix to CSR format. Labels are real valued,
+// so the same format serves regression targets.
+// Blank lines are ignored, malformed ones fail with
+// ParseError unless dataset is lenient
+func (d *Dataset) FromSVMFile(path string,
+	maxrows int32, isBinary bool) error {
+
+	d.skipped = nil
 	matrix := MakeCOO(isBinary)
+	var labels []float64
 	var rowIdx uint64
-	// var meanTarget uint64
-	for {
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
+	var sumTarget float64
+	var row Sample
+	err := eachLine(path, func(num int, line []byte) (bool, error) {
+		if len(line) == 0 {
+			return true, nil
 		}
 
-		line = strings.TrimSpace(line)
-		tokens := strings.Split(line, " ")
-
-		label, err := strconv.ParseUint(tokens[0], 10, 8)
+		label, sample, err := parseSVMLine(line, isBinary, row[:0])
+		row = sample
 		if err != nil {
-			log.Fatal(err)
-		}
-		// meanTarget += label
-		d.labels = append(d.labels, uint8(label))
-
-		for _, token := range tokens[1:] {
-			// [0] = key, [1] = value
-			parts := strings.Split(token, ":")
-			colIdx, _ := strconv.ParseUint(parts[0], 10, 32)
-			if isBinary {
-				matrix.Set(rowIdx, uint64(colIdx), 1)
-			} else {
-				val, _ := strconv.ParseFloat(parts[1], 64)
-				matrix.Set(rowIdx, uint64(colIdx), val)
-			}
+			return true, d.malformed(path, num, rowIdx, err)
+		}
+		sumTarget += label
+		labels = append(labels, label)
+		for _, feature := range row {
+			matrix.Set(rowIdx, feature.Key, feature.Value)
 		}
 
 		rowIdx++
-		if maxrows == int32(rowIdx) {
-			break
-		}
+		return maxrows != int32(rowIdx), nil
+	})
+	if err != nil {
+		return err
 	}
+	matrix.Reshape(rowIdx, 0)
 
 	csr := MakeCSR(isBinary)
+	csr.SetStorage(d.storage)
 	csr.FromCOO(matrix)
 	d.data = csr
-	// d.meanTarget = float64(meanTarget) / float64(rowIdx)
-	d.data.CacheRows()
-	log.Println(d)
-}
-
-func (d *Dataset) LoadSampleWeights(path string) {
-	file, err := os.Open(path)
-	if err != nil {
-		log.Fatal(err)
+	d.labels = labels
+	d.meanTarget = 0
+	if rowIdx > 0 {
+		d.meanTarget = sumTarget / float64(rowIdx)
 	}
-	defer file.Close()
+	d.logLoaded(path)
+	return nil
+}
 
-	reader := bufio.NewReader(file)
-	weights := make([]float64, 0)
+// LoadSampleWeights reads weight of every row of
+// dataset, one per line. Number of weights must
+// be equal to number of rows of loaded dataset, or
+// to number of lines of data file if some of them
+// were skipped by lenient loader, then weights of
+// skipped lines are dropped
+func (d *Dataset) LoadSampleWeights(path string) error {
+	var weights []float64
 	wsum := 0.0
-	for {
-		line, err := reader.ReadString('\n')
-		if err == io.EOF {
-			break
+	err := eachLine(path, func(num int, line []byte) (bool, error) {
+		if len(line) == 0 {
+			return true, nil
 		}
-
-		line = strings.TrimSpace(line)
-		w, err := strconv.ParseFloat(line, 64)
+		w, err := strconv.ParseFloat(string(line), 64)
 		if err != nil {
-			log.Fatal(err)
+			return false, &ParseError{path, num, err}
 		}
 
 		weights = append(weights, w)
 		wsum += w
+		return true, nil
+	})
+	if err != nil {
+		return err
 	}
+	if d.data != nil && len(d.skipped) > 0 &&
+		uint64(len(weights)) == d.data.nrows+uint64(len(d.skipped)) {
+		weights, wsum = dropSkipped(weights, d.skipped)
+	}
+	if d.data != nil && uint64(len(weights)) != d.data.nrows {
+		if len(d.skipped) > 0 {
+			return fmt.Errorf("%s: %d weights for %d rows, %d malformed lines were skipped",
+				path, len(weights), d.data.nrows, len(d.skipped))
+		}
+		return fmt.Errorf("%s: %d weights for %d rows", path, len(weights), d.data.nrows)
+	}
+
 	d.isWeighted = true
 	d.sampleWeights = weights
 	d.weightsSum = wsum
+	if d.rows != nil {
+		d.weightsSum = 0
+		for _, idx := range d.rows {
+			d.weightsSum += weights[idx]
+		}
+	}
+	return nil
+}
+
+// dropSkipped removes weights at sorted positions
+// of skipped lines, returns the rest and their sum
+func dropSkipped(weights []float64, skipped []uint64) ([]float64, float64) {
+	kept := weights[:0]
+	var wsum float64
+	for i, w := range weights {
+		if len(skipped) > 0 && skipped[0] == uint64(i) {
+			skipped = skipped[1:]
+			continue
+		}
+		kept = append(kept, w)
+		wsum += w
+	}
+	return kept, wsum
+}
+
+// LoadFeatureNames reads name of every
+// column of dataset, one per line
+func (d *Dataset) LoadFeatureNames(path string) error {
+	var names []string
+	err := eachLine(path, func(num int, line []byte) (bool, error) {
+		names = append(names, string(line))
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.featureNames = names
+	return nil
 }
 
-func (d *Dataset) LoadFeatureNames(path string) {
+// FromCSVFile reads file to dataset via
+// rows --> coo matrix --> csr matrix transformation.
+// File must have a header. Columns are chosen and
+// encoded by enc, which should be shared between
+// train and validation files to keep column indexes
+// consistent. Malformed records fail with ParseError
+// unless dataset is lenient
+func (d *Dataset) FromCSVFile(path string, maxrows int32, enc *CSVEncoder) error {
 	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	names := make([]string, 0)
-	var idx uint64
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.ReuseRecord = true
+	if enc.spec.Comma != 0 {
+		reader.Comma = enc.spec.Comma
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := enc.bind(header); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	d.skipped = nil
+	isBinary := len(enc.spec.Numeric) == 0
+	matrix := MakeCOO(isBinary)
+	var labels, weights []float64
+	var rowIdx uint64
+	var sumTarget, wsum float64
 	for {
-		line, err := reader.ReadString('\n')
+		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			if err := d.malformed(path, perr.Line, rowIdx, perr.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-		line = strings.TrimSpace(line)
-		names = append(names, line)
+		label, weight, err := enc.encode(record, rowIdx, matrix)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			if err := d.malformed(path, line, rowIdx, err); err != nil {
+				return err
+			}
+			continue
+		}
+		sumTarget += label
+		labels = append(labels, label)
+		if enc.weightCol >= 0 {
+			weights = append(weights, weight)
+			wsum += weight
+		}
 
-		idx++
+		rowIdx++
+		if maxrows == int32(rowIdx) {
+			break
+		}
 	}
+	matrix.Reshape(rowIdx, enc.NCols())
 
-	d.featureNames = names
+	csr := MakeCSR(isBinary)
+	csr.SetStorage(d.storage)
+	csr.FromCOO(matrix)
+	d.data = csr
+	d.labels = labels
+	d.isWeighted = enc.weightCol >= 0
+	d.sampleWeights = weights
+	d.weightsSum = wsum
+	d.meanTarget = 0
+	if rowIdx > 0 {
+		d.meanTarget = sumTarget / float64(rowIdx)
+	}
+	d.featureNames = enc.FeatureNames()
+	d.logLoaded(path)
+	return nil
 }
 
-// FromCSVFile reads file to dataset via
-// rows --> coo matrix --> csr matrix transformation
-func (d *Dataset) FromCSVFile(path string, maxrows int32) {
-	file, err := os.Open(path)
+// FromVWFile reads file of raw string features in
+// Vowpal Wabbit like format
+//
+//	label [importance] |namespace feature[:value] ...
+//
+// Features are hashed by v. Label -1 is read as 0,
+// importance is used as sample weight. Lines with
+// malformed label or importance fail with ParseError
+// unless dataset is lenient
+func (d *Dataset) FromVWFile(path string, maxrows int32, v *Vectorizer) error {
+	d.skipped = nil
+	matrix := MakeCOO(false)
+	var labels, weights []float64
+	var rowIdx uint64
+	var sumTarget, wsum float64
+	var isWeighted bool
+	var row Sample
+	err := eachLine(path, func(num int, raw []byte) (bool, error) {
+		if len(raw) == 0 {
+			return true, nil
+		}
+
+		line := string(raw)
+		head, features := line, ""
+		if bar := strings.IndexByte(line, '|'); bar >= 0 {
+			head, features = line[:bar], line[bar:]
+		}
+		tokens := strings.Fields(head)
+		if len(tokens) == 0 {
+			return true, d.malformed(path, num, rowIdx, errors.New("no label"))
+		}
+
+		label, err := strconv.ParseFloat(tokens[0], 64)
+		if err != nil {
+			return true, d.malformed(path, num, rowIdx, err)
+		}
+		if label == -1 {
+			label = 0
+		}
+
+		weight := 1.0
+		if len(tokens) > 1 && !strings.HasPrefix(tokens[1], "'") {
+			weight, err = strconv.ParseFloat(tokens[1], 64)
+			if err != nil {
+				return true, d.malformed(path, num, rowIdx, err)
+			}
+			isWeighted = true
+		}
+
+		sumTarget += label
+		labels = append(labels, label)
+		weights = append(weights, weight)
+		wsum += weight
+
+		row = v.TransformLine(features, row[:0])
+		for _, feature := range row {
+			matrix.Set(rowIdx, feature.Key, feature.Value)
+		}
+
+		rowIdx++
+		return maxrows != int32(rowIdx), nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
+	matrix.Reshape(rowIdx, 0)
 
-	// TODO
-	// parse csv rows
-	// zip values with index columns
-	// add to coo
-	// make csr compression
+	csr := MakeCSR(false)
+	csr.SetStorage(d.storage)
+	csr.FromCOO(matrix)
+	d.data = csr
+	d.labels = labels
+	d.isWeighted = isWeighted
+	d.sampleWeights = nil
+	d.weightsSum = 0
+	if isWeighted {
+		d.sampleWeights = weights
+		d.weightsSum = wsum
+	}
+	d.meanTarget = 0
+	if rowIdx > 0 {
+		d.meanTarget = sumTarget / float64(rowIdx)
+	}
+	d.logLoaded(path)
+	return nil
+}
+
+// logLoaded logs summary of dataset loaded from path
+func (d *Dataset) logLoaded(path string) {
+	log.Println(d)
+	if len(d.skipped) > 0 {
+		log.Printf("%s: skipped %d malformed lines", path, len(d.skipped))
+	}
 }
 
 // MakeDataset creates Dataset object
@@ -228,12 +617,14 @@ func MakeDataset() *Dataset {
 }
 
 // MakeAndLoadDataset creates dataset object
-// and loads data from file
+// and loads data from libsvm file
 func MakeAndLoadDataset(path string,
-	maxrows int32, isBinary bool) *Dataset {
+	maxrows int32, isBinary bool) (*Dataset, error) {
 	d := MakeDataset()
-	d.FromSVMFile(path, maxrows, isBinary)
-	return d
+	if err := d.FromSVMFile(path, maxrows, isBinary); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
 func (d *Dataset) String() string {
@@ -0,0 +1,810 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func loadSVM(t *testing.T, path string, isBinary bool) *Dataset {
+	d, err := MakeAndLoadDataset(path, -1, isBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSVMScanner(t *testing.T) {
+	input := "1 0:1 3:0.5\n\n0 2:2\n1.5 7:1 0:0\n"
+
+	scanner := MakeSVMScanner(strings.NewReader(input), false)
+	expected := []Sample{
+		{{0, 1}, {3, 0.5}},
+		{{2, 2}},
+		{{7, 1}},
+	}
+	labels := []float64{1, 0, 1.5}
+	i := 0
+	for scanner.Scan() {
+		if !reflect.DeepEqual(scanner.Sample(), expected[i]) {
+			t.Errorf("sample %d: %v != %v", i, scanner.Sample(), expected[i])
+		}
+		if scanner.Label() != labels[i] {
+			t.Errorf("label %d: %v != %v", i, scanner.Label(), labels[i])
+		}
+		i++
+	}
+	if scanner.Err() != nil || i != len(expected) {
+		t.Errorf("read %d samples, err=%v", i, scanner.Err())
+	}
+
+	scanner = MakeSVMScanner(strings.NewReader("1 0:1\n0 x:1\n"), false)
+	for scanner.Scan() {
+	}
+	if scanner.Err() == nil || !strings.Contains(scanner.Err().Error(), "line 2") {
+		t.Errorf("expected error at line 2, got %v", scanner.Err())
+	}
+
+	for _, line := range []string{"1 0:abc\n", "1 1:\n"} {
+		scanner = MakeSVMScanner(strings.NewReader(line), true)
+		for scanner.Scan() {
+		}
+		if scanner.Err() == nil {
+			t.Errorf("binary scanner accepts %q", line)
+		}
+	}
+}
+
+func TestFromCSVFile(t *testing.T) {
+	input := "id,click,w,price,site,app\n" +
+		"1,1,2.0,0.5,a.com,x\n" +
+		"2,0,1.0,,b.com,x\n" +
+		"3,1,1.0,1.5,a.com,\n"
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	enc := MakeCSVEncoder(CSVSpec{
+		Label:       "click",
+		Weight:      "w",
+		Numeric:     []string{"price"},
+		Categorical: []string{"site", "app"},
+	})
+	d := MakeDataset()
+	if err := d.FromCSVFile(path, -1, enc); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"price", "site=a.com", "app=x", "site=b.com"}
+	if !reflect.DeepEqual(d.FeatureNames(), names) {
+		t.Errorf("wrong feature names %v", d.FeatureNames())
+	}
+
+	rows := []Sample{
+		{{0, 0.5}, {1, 1}, {2, 1}},
+		{{2, 1}, {3, 1}},
+		{{0, 1.5}, {1, 1}},
+	}
+	labels := []float64{1, 0, 1}
+	for i, expected := range rows {
+		row := d.Row(uint64(i))
+		sort.Slice(row, func(a, b int) bool { return row[a].Key < row[b].Key })
+		if !reflect.DeepEqual(row, expected) {
+			t.Errorf("row %d: %v != %v", i, row, expected)
+		}
+		if d.Label(uint64(i)) != labels[i] {
+			t.Errorf("label %d: %v != %v", i, d.Label(uint64(i)), labels[i])
+		}
+	}
+	if d.WeightsSum() != 4.0 || d.SampleWeight(0) != 2.0 {
+		t.Errorf("wrong sample weights")
+	}
+
+	hashed := MakeCSVEncoder(CSVSpec{
+		Label:       "click",
+		Categorical: []string{"site", "app"},
+		HashBits:    8,
+	})
+	d = MakeDataset()
+	if err := d.FromCSVFile(path, -1, hashed); err != nil {
+		t.Fatal(err)
+	}
+	if d.NCols() != 256 || d.NRows() != 3 {
+		t.Errorf("wrong shape of hashed dataset: %d x %d", d.NRows(), d.NCols())
+	}
+	if len(d.Row(0)) != 2 || len(d.Row(2)) != 1 {
+		t.Errorf("wrong hashed rows %v %v", d.Row(0), d.Row(2))
+	}
+	if d.FeatureNames() != nil {
+		t.Errorf("hashed columns should have no names")
+	}
+	fields := hashed.Fields()
+	for _, v := range d.Row(2) {
+		if fields.FieldOf(v.Key) != 0 {
+			t.Errorf("wrong field of hashed column %d", v.Key)
+		}
+	}
+}
+
+func TestMurmur3(t *testing.T) {
+	cases := []struct {
+		s        string
+		seed     uint32
+		expected uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"hello", 0, 0x248bfa47},
+		{"Hello, world!", 1234, 0xfaf6cdb3},
+	}
+	for _, c := range cases {
+		if h := Murmur3(c.s, c.seed); h != c.expected {
+			t.Errorf("Murmur3(%q, %d) = %#x, expected %#x", c.s, c.seed, h, c.expected)
+		}
+	}
+}
+
+func TestVectorizer(t *testing.T) {
+	v := MakeVectorizer(18, 42, false, true)
+	row := v.TransformLine("|user age:25 gender=m |ad:0.5 id=7 bad:0", nil)
+	expected := Sample{
+		{v.Hash("user^age"), 25},
+		{v.Hash("user^gender=m"), 1},
+		{v.Hash("ad^id=7"), 0.5},
+	}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("%v != %v", row, expected)
+	}
+	for _, f := range row {
+		if f.Key >= v.NCols() {
+			t.Errorf("key %d is out of hashed space", f.Key)
+		}
+	}
+	if names := v.Lookup(row[1].Key); !reflect.DeepEqual(names, []string{"user^gender=m"}) {
+		t.Errorf("wrong reverse dictionary %v", names)
+	}
+
+	wide := MakeVectorizer(40, 42, true, false)
+	if wide.Hash("a") == MakeVectorizer(40, 43, true, false).Hash("a") {
+		t.Error("seed does not change hash")
+	}
+	if wide.Lookup(wide.Hash("a")) != nil {
+		t.Error("reverse dictionary is kept")
+	}
+
+	path := filepath.Join(t.TempDir(), "data.vw")
+	input := "1 2.0 |a x y:3\n-1 |a x |b z\n"
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := MakeDataset()
+	if err := d.FromVWFile(path, -1, v); err != nil {
+		t.Fatal(err)
+	}
+	if d.NRows() != 2 || d.Label(1) != 0 || d.SampleWeight(0) != 2.0 || d.SampleWeight(1) != 1.0 {
+		t.Errorf("wrong dataset %v", d)
+	}
+	if len(d.Row(0)) != 2 || len(d.Row(1)) != 2 {
+		t.Errorf("wrong rows %v %v", d.Row(0), d.Row(1))
+	}
+}
+
+func TestBinaryDataset(t *testing.T) {
+	dir := t.TempDir()
+	input := "1 0:0.5 3:2\n0 2:1\n1 1:1 3:1\n"
+	if err := os.WriteFile(filepath.Join(dir, "d.svm"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w.txt"), []byte("1\n2\n3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte("a\nb\nc\nd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, isBinary := range []bool{false, true} {
+		d := loadSVM(t, filepath.Join(dir, "d.svm"), isBinary)
+		if err := d.LoadSampleWeights(filepath.Join(dir, "w.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.LoadFeatureNames(filepath.Join(dir, "f.txt")); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, "d.bin")
+		if err := d.SaveBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded := MakeDataset()
+		if err := loaded.LoadBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		assertSameDataset(t, loaded, d)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.bin"), []byte("not a dataset at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MakeDataset().LoadBinary(filepath.Join(dir, "bad.bin")); err == nil {
+		t.Error("malformed dataset is loaded")
+	}
+
+	// counts of header are checked before allocation,
+	// columns are checked against number of columns
+	var files [][]byte
+	for _, nrows := range []uint64{1 << 60, math.MaxUint64} {
+		var buf bytes.Buffer
+		header := datasetHeader{Magic: datasetMagic, Version: datasetVersion, Flags: binaryFlag, NRows: nrows}
+		binary.Write(&buf, binary.LittleEndian, header)
+		buf.Write(make([]byte, 8))
+		files = append(files, buf.Bytes())
+	}
+	if err := loadSVM(t, filepath.Join(dir, "d.svm"), false).SaveBinary(filepath.Join(dir, "d.bin")); err != nil {
+		t.Fatal(err)
+	}
+	narrow, err := os.ReadFile(filepath.Join(dir, "d.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint64(narrow[16:], 3)
+	files = append(files, narrow)
+	for i, file := range files {
+		path := filepath.Join(dir, "bad.bin")
+		if err := os.WriteFile(path, file, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := MakeDataset().LoadBinary(path); !errors.Is(err, ErrBadDataset) {
+			t.Errorf("file %d: expected malformed dataset error, got %v", i, err)
+		}
+		if err := MakeDataset().MapBinary(path); !errors.Is(err, ErrBadDataset) {
+			t.Errorf("file %d: expected malformed mapped dataset error, got %v", i, err)
+		}
+	}
+}
+
+func TestEmptyRows(t *testing.T) {
+	dir := t.TempDir()
+	input := "1 0:1 1:1\n0 1:1\n1\n0 2:0\n"
+	if err := os.WriteFile(filepath.Join(dir, "d.svm"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w.txt"), []byte("1\n2\n3\n4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// rows without features at the end are kept
+	for _, isBinary := range []bool{false, true} {
+		d := loadSVM(t, filepath.Join(dir, "d.svm"), isBinary)
+		if d.NRows() != 4 || d.MeanTarget() != 0.5 || len(d.Row(2)) != 0 {
+			t.Fatalf("%d rows, mean target %v", d.NRows(), d.MeanTarget())
+		}
+		if err := d.LoadSampleWeights(filepath.Join(dir, "w.txt")); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, "d.bin")
+		if err := d.SaveBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		mapped := MakeDataset()
+		if err := mapped.MapBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		assertSameDataset(t, mapped, d)
+		if err := mapped.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func assertSameDataset(t *testing.T, d, expected *Dataset) {
+	t.Helper()
+	if d.NRows() != expected.NRows() || d.NCols() != expected.NCols() || d.Nnz() != expected.Nnz() {
+		t.Fatalf("shape %v != %v", d, expected)
+	}
+	if !reflect.DeepEqual(d.FeatureNames(), expected.FeatureNames()) {
+		t.Errorf("names %v != %v", d.FeatureNames(), expected.FeatureNames())
+	}
+	if d.WeightsSum() != expected.WeightsSum() || d.MeanTarget() != expected.MeanTarget() {
+		t.Errorf("meta differs")
+	}
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		if !reflect.DeepEqual(d.Row(i), expected.Row(i)) {
+			t.Errorf("row %d: %v != %v", i, d.Row(i), expected.Row(i))
+		}
+		if d.Label(i) != expected.Label(i) || d.SampleWeight(i) != expected.SampleWeight(i) {
+			t.Errorf("row %d: label or weight differs", i)
+		}
+	}
+}
+
+func TestMapBinary(t *testing.T) {
+	dir := t.TempDir()
+	input := "1 0:0.5 3:2\n0 2:1\n1 1:1 3:1\n"
+	if err := os.WriteFile(filepath.Join(dir, "d.svm"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, isBinary := range []bool{false, true} {
+		d := loadSVM(t, filepath.Join(dir, "d.svm"), isBinary)
+		path := filepath.Join(dir, "d.bin")
+		if err := d.SaveBinary(path); err != nil {
+			t.Fatal(err)
+		}
+
+		mapped := MakeDataset()
+		if err := mapped.MapBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		assertSameDataset(t, mapped, d)
+
+		var i uint64
+		for i = 0; i < d.NRows(); i++ {
+			view := mapped.View(i)
+			row := make(Sample, view.Len())
+			for j := range row {
+				row[j] = view.At(j)
+			}
+			if !reflect.DeepEqual(row, d.Row(i)) {
+				t.Errorf("view %d: %v != %v", i, row, d.Row(i))
+			}
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			view := mapped.View(1)
+			for j := 0; j < view.Len(); j++ {
+				view.At(j)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("view allocates %v times", allocs)
+		}
+
+		if err := mapped.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestCompactStorage(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, input string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	exact := write("exact.svm", "1 0:0.5 3:2\n0 2:1\n1 1:1 3:1\n")
+	inexact := write("inexact.svm", "1 0:0.1 3:2\n0 2:1\n")
+	ones := write("ones.svm", "1 0:1 3:1\n0 2:1\n")
+
+	cases := []struct {
+		path      string
+		storage   Storage
+		narrowIdx bool
+		values    string
+	}{
+		{exact, AutoStorage, true, "float32"},
+		{exact, WideStorage, false, "float64"},
+		{inexact, AutoStorage, true, "float64"},
+		{inexact, CompactStorage, true, "float32"},
+		{ones, AutoStorage, true, "none"},
+		{ones, WideStorage, false, "float64"},
+	}
+	for _, c := range cases {
+		d := MakeDataset()
+		d.SetStorage(c.storage)
+		if err := d.FromSVMFile(c.path, -1, false); err != nil {
+			t.Fatal(err)
+		}
+		wide := MakeDataset()
+		wide.SetStorage(WideStorage)
+		if err := wide.FromSVMFile(c.path, -1, false); err != nil {
+			t.Fatal(err)
+		}
+
+		csr := d.data
+		values := "none"
+		if csr.dat32 != nil {
+			values = "float32"
+		} else if csr.dat != nil {
+			values = "float64"
+		}
+		if (csr.ja32 != nil) != c.narrowIdx || values != c.values {
+			t.Errorf("%s storage %d: narrow index %t, values %s",
+				filepath.Base(c.path), c.storage, csr.ja32 != nil, values)
+		}
+
+		if c.storage != CompactStorage {
+			assertSameDataset(t, d, wide)
+		}
+
+		path := filepath.Join(dir, "d.bin")
+		if err := d.SaveBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded := MakeDataset()
+		if err := loaded.LoadBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		assertSameDataset(t, loaded, d)
+		mapped := MakeDataset()
+		if err := mapped.MapBinary(path); err != nil {
+			t.Fatal(err)
+		}
+		assertSameDataset(t, mapped, d)
+		if (mapped.data.ja32 != nil) != c.narrowIdx || (mapped.data.dat32 != nil) != (values == "float32") {
+			t.Errorf("%s storage %d: widths are lost in binary file", filepath.Base(c.path), c.storage)
+		}
+		mapped.Close()
+	}
+
+	// wide files are laid out as in version 1
+	d := MakeDataset()
+	d.SetStorage(WideStorage)
+	if err := d.FromSVMFile(exact, -1, false); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "v1.bin")
+	if err := d.SaveBinary(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[4], data[5] = 1, 0
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded := MakeDataset()
+	if err := loaded.LoadBinary(path); err != nil {
+		t.Fatal(err)
+	}
+	assertSameDataset(t, loaded, d)
+}
+
+func TestRowInto(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d.svm")
+	if err := os.WriteFile(path, []byte("1 0:0.5 3:2\n0 2:1\n1 1:1 3:1 4:0.25\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := loadSVM(t, path, false)
+
+	var buf Sample
+	var i uint64
+	for i = 0; i < d.NRows(); i++ {
+		buf = d.RowInto(i, buf[:0])
+		if !reflect.DeepEqual(buf, d.Row(i)) {
+			t.Errorf("row %d: %v != %v", i, buf, d.Row(i))
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		for i = 0; i < d.NRows(); i++ {
+			buf = d.RowInto(i, buf[:0])
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("RowInto allocates %v times", allocs)
+	}
+
+	// rows are cached only on request
+	expected := d.Row(2)
+	d.CacheRows()
+	allocs = testing.AllocsPerRun(100, func() {
+		d.Row(2)
+	})
+	if allocs != 0 || !reflect.DeepEqual(d.Row(2), expected) {
+		t.Errorf("cached Row allocates %v times", allocs)
+	}
+}
+
+func TestShuffle(t *testing.T) {
+	dir := t.TempDir()
+	input := "1 0:0.5 3:2\n0 2:1\n1 1:1 3:1 4:0.25\n0 0:3\n1 2:2 4:1\n"
+	if err := os.WriteFile(filepath.Join(dir, "d.svm"), []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w.txt"), []byte("1\n2\n3\n4\n5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := loadSVM(t, filepath.Join(dir, "d.svm"), false)
+	if err := d.LoadSampleWeights(filepath.Join(dir, "w.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	shuffled := d.Shuffle(1)
+	if shuffled.NRows() != d.NRows() || shuffled.Nnz() != d.Nnz() ||
+		shuffled.WeightsSum() != d.WeightsSum() || shuffled.MeanTarget() != d.MeanTarget() {
+		t.Fatalf("meta differs: %v vs %v", shuffled, d)
+	}
+
+	// every row moves together with its label and weight
+	seen := make(map[uint64]bool)
+	moved := false
+	var i, j uint64
+	for i = 0; i < shuffled.NRows(); i++ {
+		for j = 0; j < d.NRows(); j++ {
+			if !seen[j] && reflect.DeepEqual(shuffled.Row(i), d.Row(j)) {
+				break
+			}
+		}
+		if j == d.NRows() {
+			t.Fatalf("row %d %v is not in dataset", i, shuffled.Row(i))
+		}
+		seen[j] = true
+		moved = moved || i != j
+		if shuffled.Label(i) != d.Label(j) || shuffled.SampleWeight(i) != d.SampleWeight(j) {
+			t.Errorf("row %d: label or weight is lost", i)
+		}
+	}
+	if !moved {
+		t.Error("rows are not shuffled")
+	}
+
+	again := d.Shuffle(1)
+	twice := shuffled.Shuffle(2)
+	for i = 0; i < d.NRows(); i++ {
+		if !reflect.DeepEqual(again.Row(i), shuffled.Row(i)) {
+			t.Errorf("row %d: same seed gives different order", i)
+		}
+	}
+
+	// views are saved as plain datasets
+	path := filepath.Join(dir, "d.bin")
+	if err := twice.SaveBinary(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := MakeDataset()
+	if err := loaded.LoadBinary(path); err != nil {
+		t.Fatal(err)
+	}
+	assertSameDataset(t, loaded, twice)
+}
+
+func TestCOOShuffleRows(t *testing.T) {
+	coo := MakeCOO(false)
+	rows := [][]float64{{1, 2}, {0, 3}, {4, 0}, {5, 6}}
+	for i, row := range rows {
+		for j, v := range row {
+			coo.Set(uint64(i), uint64(j), v)
+		}
+	}
+	perm := coo.ShuffleRows(3)
+
+	csr := MakeCSR(false)
+	csr.FromCOO(coo)
+	for i, row := range rows {
+		expected := Sample{}
+		for j, v := range row {
+			if v != 0 {
+				expected = append(expected, Feature{uint64(j), v})
+			}
+		}
+		if got := csr.BuildRow(perm[i]); !reflect.DeepEqual(got, expected) {
+			t.Errorf("row %d moved to %d: %v != %v", i, perm[i], got, expected)
+		}
+	}
+}
+
+func TestSplits(t *testing.T) {
+	dir := t.TempDir()
+	var input, weights strings.Builder
+	for i := 0; i < 100; i++ {
+		label := 0
+		if i%4 == 0 {
+			label = 1
+		}
+		fmt.Fprintf(&input, "%d %d:1 %d:0.5\n", label, i%7, 7+i%3)
+		fmt.Fprintf(&weights, "%d\n", i+1)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "d.svm"), []byte(input.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "w.txt"), []byte(weights.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := loadSVM(t, filepath.Join(dir, "d.svm"), false)
+	if err := d.LoadSampleWeights(filepath.Join(dir, "w.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.LoadFeatureNames(filepath.Join(dir, "f.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// parts must cover dataset once, rows are found
+	// by weight, which is unique
+	checkParts := func(name string, parts []*Dataset, sizes []uint64) {
+		t.Helper()
+		seen := make(map[float64]bool)
+		for i, part := range parts {
+			if part.NRows() != sizes[i] {
+				t.Errorf("%s: part %d has %d rows, expected %d", name, i, part.NRows(), sizes[i])
+			}
+			if !reflect.DeepEqual(part.FeatureNames(), d.FeatureNames()) {
+				t.Errorf("%s: names are lost", name)
+			}
+			var sum float64
+			var j uint64
+			for j = 0; j < part.NRows(); j++ {
+				w := part.SampleWeight(j)
+				row := uint64(w) - 1
+				if seen[w] || !reflect.DeepEqual(part.Row(j), d.Row(row)) || part.Label(j) != d.Label(row) {
+					t.Errorf("%s: row %d of part %d is wrong", name, j, i)
+				}
+				seen[w] = true
+				sum += w
+			}
+			if part.WeightsSum() != sum {
+				t.Errorf("%s: weights sum %v != %v", name, part.WeightsSum(), sum)
+			}
+		}
+		if len(seen) != int(d.NRows()) {
+			t.Errorf("%s: %d rows of %d are covered", name, len(seen), d.NRows())
+		}
+	}
+
+	split := func(parts []*Dataset, err error) []*Dataset {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parts
+	}
+
+	checkParts("random", split(d.SplitRandom(1, 0.7, 0.2)), []uint64{70, 20, 10})
+	checkParts("stratified", split(d.SplitStratified(1, 0.6)), []uint64{60, 40})
+	ordered := split(d.SplitOrdered(0.9))
+	checkParts("ordered", ordered, []uint64{90, 10})
+	if ordered[1].SampleWeight(0) != 91 {
+		t.Error("ordered split does not keep order")
+	}
+
+	for _, part := range split(d.SplitStratified(2, 0.6)) {
+		if part.MeanTarget() != d.MeanTarget() {
+			t.Errorf("stratified part has mean target %v, expected %v", part.MeanTarget(), d.MeanTarget())
+		}
+	}
+	if !reflect.DeepEqual(split(d.SplitRandom(1, 0.5))[0].rows, split(d.SplitRandom(1, 0.5))[0].rows) {
+		t.Error("same seed gives different splits")
+	}
+
+	// splits of views refer to rows of original dataset
+	checkParts("nested", append(split(ordered[0].SplitRandom(3, 0.5)), ordered[1]), []uint64{45, 45, 10})
+
+	for _, fractions := range [][]float64{{-0.5}, {0.7, 0.5}, {math.NaN()}} {
+		if _, err := d.SplitRandom(1, fractions...); err == nil {
+			t.Errorf("fractions %v are accepted", fractions)
+		}
+	}
+	for _, k := range []int{1, 101} {
+		if _, err := d.KFold(k, 1, false); err == nil {
+			t.Errorf("%d folds are accepted", k)
+		}
+	}
+
+	folds, err := d.KFold(4, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var valids []*Dataset
+	for _, fold := range folds {
+		if fold.Train.NRows()+fold.Valid.NRows() != d.NRows() {
+			t.Error("fold does not cover dataset")
+		}
+		if math.Abs(fold.Valid.MeanTarget()-d.MeanTarget()) > 1.0/25 {
+			t.Errorf("stratified fold has mean target %v", fold.Valid.MeanTarget())
+		}
+		valids = append(valids, fold.Valid)
+	}
+	checkParts("kfold", valids, []uint64{25, 25, 25, 25})
+}
+
+func TestLoaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"d.svm":  "1 0:1 3:0.5\n0 x:1\n\n1 2:2\n0 1:y\n",
+		"d.csv":  "click,price\n1,0.5\n0,abc\n1,1.5\n",
+		"d.vw":   "1 |a x\nfoo |a y\n-1 2 |a z\n",
+		"w.txt":  "1\n2\n",
+		"w3.txt": "1\n2\n3\n",
+		"w4.txt": "1\n2\n3\n4\n",
+		"bw.txt": "1\noops\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaders := []struct {
+		name    string
+		line    int
+		skipped uint64
+		load    func(d *Dataset) error
+	}{
+		{"d.svm", 2, 2, func(d *Dataset) error {
+			return d.FromSVMFile(filepath.Join(dir, "d.svm"), -1, false)
+		}},
+		{"d.svm", 2, 2, func(d *Dataset) error {
+			return d.FromSVMFile(filepath.Join(dir, "d.svm"), -1, true)
+		}},
+		{"d.csv", 3, 1, func(d *Dataset) error {
+			enc := MakeCSVEncoder(CSVSpec{Label: "click", Numeric: []string{"price"}})
+			return d.FromCSVFile(filepath.Join(dir, "d.csv"), -1, enc)
+		}},
+		{"d.vw", 2, 1, func(d *Dataset) error {
+			return d.FromVWFile(filepath.Join(dir, "d.vw"), -1, MakeVectorizer(8, 0, false, false))
+		}},
+	}
+	for _, l := range loaders {
+		var perr *ParseError
+		err := l.load(MakeDataset())
+		if !errors.As(err, &perr) || perr.Line != l.line || !strings.Contains(err.Error(), l.name) {
+			t.Errorf("%s: expected error at line %d, got %v", l.name, l.line, err)
+		}
+
+		d := MakeDataset()
+		d.SetLenient(true)
+		if err := l.load(d); err != nil {
+			t.Fatalf("%s: %v", l.name, err)
+		}
+		if d.NRows() != 2 || d.Skipped() != l.skipped {
+			t.Errorf("%s: %d rows, %d skipped", l.name, d.NRows(), d.Skipped())
+		}
+	}
+
+	d := MakeDataset()
+	d.SetLenient(true)
+	if err := d.FromSVMFile(filepath.Join(dir, "d.svm"), -1, false); err != nil {
+		t.Fatal(err)
+	}
+	if d.Label(1) != 1 || d.Row(1)[0].Key != 2 {
+		t.Errorf("malformed rows are kept: %v", d.Row(1))
+	}
+	if err := d.LoadSampleWeights(filepath.Join(dir, "w.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	// failed loads leave weights intact
+	var perr *ParseError
+	if err := d.LoadSampleWeights(filepath.Join(dir, "bw.txt")); !errors.As(err, &perr) || perr.Line != 2 {
+		t.Errorf("expected error at line 2, got %v", err)
+	}
+	if err := d.LoadSampleWeights(filepath.Join(dir, "w3.txt")); err == nil {
+		t.Error("3 weights are loaded for 2 rows")
+	}
+	if d.SampleWeight(1) != 2 || d.WeightsSum() != 3 {
+		t.Errorf("weights are changed by failed load")
+	}
+
+	// weights of all lines lose those of skipped ones
+	if err := d.LoadSampleWeights(filepath.Join(dir, "w4.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if d.SampleWeight(0) != 1 || d.SampleWeight(1) != 3 || d.WeightsSum() != 4 {
+		t.Errorf("wrong weights %v", d.sampleWeights)
+	}
+
+	if err := d.LoadFeatureNames(filepath.Join(dir, "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected missing file, got %v", err)
+	}
+	if _, err := MakeAndLoadDataset(filepath.Join(dir, "missing.svm"), -1, false); err == nil {
+		t.Error("missing file is loaded")
+	}
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// FieldMap tells which field, i.e. source column or
+// namespace, every feature belongs to. Fields are
+// numbered from 0
+type FieldMap struct {
+	// field i owns keys [starts[i], starts[i+1])
+	starts []uint64
+	// field of every key, used if starts is nil
+	table []int32
+}
+
+// MakeFieldRanges creates map for features grouped in
+// contiguous blocks, field i starts at key starts[i].
+// Starts must be sorted
+func MakeFieldRanges(starts []uint64) *FieldMap {
+	return &FieldMap{starts: append([]uint64{}, starts...)}
+}
+
+// MakeFieldTable creates map with explicit field of
+// every key. Negative field means feature has no field
+func MakeFieldTable(fields []int32) *FieldMap {
+	return &FieldMap{table: append([]int32{}, fields...)}
+}
+
+// FieldOf returns field of feature or -1 if it is unknown
+func (m *FieldMap) FieldOf(key uint64) int {
+	if m.starts != nil {
+		i := sort.Search(len(m.starts), func(i int) bool {
+			return m.starts[i] > key
+		})
+		return i - 1
+	}
+
+	if key < uint64(len(m.table)) {
+		return int(m.table[key])
+	}
+	return -1
+}
+
+// Starts returns first key of every field, or nil
+// for map created by MakeFieldTable
+func (m *FieldMap) Starts() []uint64 {
+	return m.starts
+}
+
+// Table returns field of every key, or nil for
+// map created by MakeFieldRanges
+func (m *FieldMap) Table() []int32 {
+	return m.table
+}
+
+// MarshalBinary encodes field map
+func (m *FieldMap) MarshalBinary() ([]byte, error) {
+	if m.starts == nil {
+		data := make([]byte, 9+4*len(m.table))
+		data[0] = 1
+		binary.LittleEndian.PutUint64(data[1:], uint64(len(m.table)))
+		for i, field := range m.table {
+			binary.LittleEndian.PutUint32(data[9+4*i:], uint32(field))
+		}
+		return data, nil
+	}
+
+	data := make([]byte, 9+8*len(m.starts))
+	binary.LittleEndian.PutUint64(data[1:], uint64(len(m.starts)))
+	for i, start := range m.starts {
+		binary.LittleEndian.PutUint64(data[9+8*i:], start)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes field map
+func (m *FieldMap) UnmarshalBinary(data []byte) error {
+	if len(data) < 9 {
+		return errors.New("fields: data is too short")
+	}
+	isTable := data[0] == 1
+	size := binary.LittleEndian.Uint64(data[1:])
+	data = data[9:]
+
+	width := uint64(8)
+	if isTable {
+		width = 4
+	}
+	if uint64(len(data)) != size*width {
+		return errors.New("fields: wrong data length")
+	}
+
+	m.starts, m.table = nil, nil
+	if isTable {
+		m.table = make([]int32, size)
+		for i := range m.table {
+			m.table[i] = int32(binary.LittleEndian.Uint32(data[4*i:]))
+		}
+		return nil
+	}
+
+	m.starts = make([]uint64, size)
+	for i := range m.starts {
+		m.starts[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+	return nil
+}
@@ -4,13 +4,30 @@ import "math"
 
 const eps float64 = 1e-15
 
-func Logloss(p float64, y uint8, w float64) float64 {
+func Logloss(p float64, y float64, w float64) float64 {
 	p = math.Max(eps, math.Min(1-eps, p))
 	if y == 1 {
 		return -math.Log(p) * w
 	}
+	if y == 0 {
+		return -math.Log(1.0-p) * w
+	}
+
+	return -(y*math.Log(p) + (1-y)*math.Log(1.0-p)) * w
+}
+
+func SquaredError(p float64, y float64, w float64) float64 {
+	d := p - y
+	return d * d * w
+}
+
+func PoissonDeviance(p float64, y float64, w float64) float64 {
+	p = math.Max(eps, p)
+	if y == 0 {
+		return 2 * p * w
+	}
 
-	return -math.Log(1.0-p) * w
+	return 2 * (y*math.Log(y/p) - (y - p)) * w
 }
 
 func Norm(vec []float64) float64 {
@@ -31,6 +48,18 @@ func Mean(vec []float64) float64 {
 	return sum / float64(len(vec))
 }
 
+// Std returns standard deviation of
+// population of values
+func Std(vec []float64) float64 {
+	mean := Mean(vec)
+	sum := 0.0
+	for _, v := range vec {
+		sum += (v - mean) * (v - mean)
+	}
+
+	return math.Sqrt(sum / float64(len(vec)))
+}
+
 func InfNorm(vec []float64) float64 {
 	max := -math.MaxFloat64
 	for _, v := range vec {
@@ -6,9 +6,9 @@ import (
 
 // BenchmarkCSRFromCOO benchmarks csr construction performance
 func BenchmarkCSRFromCOO(b *testing.B) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 	b.ResetTimer()
@@ -28,9 +28,9 @@ func BenchmarkCSRFromCOO(b *testing.B) {
 
 // BenchmarkCSRGetRow benchmarks row access performance
 func BenchmarkCSRGetRow(b *testing.B) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 
@@ -49,9 +49,9 @@ func BenchmarkCSRGetRow(b *testing.B) {
 }
 
 func BenchmarkDatasetGetRow(b *testing.B) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 
@@ -7,9 +7,9 @@ import (
 )
 
 func TestCOOBuild(t *testing.T) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 
@@ -33,9 +33,9 @@ func TestCOOBuild(t *testing.T) {
 }
 
 func TestCSRBuildFromCOO(t *testing.T) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 
@@ -48,23 +48,23 @@ func TestCSRBuildFromCOO(t *testing.T) {
 	csr.FromCOO(coo)
 	fmt.Println(csr)
 
-	if !reflect.DeepEqual(csr.ia, []uint32{0, 2, 3, 6}) {
+	if !reflect.DeepEqual(csr.ia, []uint64{0, 2, 3, 6}) {
 		t.Error("IA wrong")
 	}
 
-	if !reflect.DeepEqual(csr.ja, c) {
+	if !reflect.DeepEqual(csr.ja32, []uint32{0, 2, 2, 0, 1, 2}) {
 		t.Error("JA wrong")
 	}
 
-	if !reflect.DeepEqual(csr.dat, d) {
+	if !reflect.DeepEqual(csr.dat32, []float32{1, 2, 3, 4, 5, 6}) {
 		t.Error("DAT wrong")
 	}
 }
 
 func TestCSRGetRowMethod(t *testing.T) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 
@@ -80,26 +80,26 @@ func TestCSRGetRowMethod(t *testing.T) {
 	two := csr.GetRow(1)
 	three := csr.GetRow(2)
 
-	if !reflect.DeepEqual(one, map[uint32]float32{0: 1, 2: 2}) {
+	if !reflect.DeepEqual(one, Sample{{0, 1}, {2, 2}}) {
 		t.Error("Wrong GetRow(0)")
 		fmt.Println(one)
 	}
 
-	if !reflect.DeepEqual(two, map[uint32]float32{2: 3}) {
+	if !reflect.DeepEqual(two, Sample{{2, 3}}) {
 		t.Error("Wrong GetRow(1)")
 		fmt.Println(two)
 	}
 
-	if !reflect.DeepEqual(three, map[uint32]float32{0: 4, 1: 5, 2: 6}) {
+	if !reflect.DeepEqual(three, Sample{{0, 4}, {1, 5}, {2, 6}}) {
 		t.Error("Wrong GetRow(2)")
 		fmt.Println(three)
 	}
 }
 
 func TestCSRBinaryGetRowMethod(t *testing.T) {
-	r := []uint32{0, 0, 1, 2, 2, 2}
-	c := []uint32{0, 2, 2, 0, 1, 2}
-	d := []float32{1, 2, 3, 4, 5, 6}
+	r := []uint64{0, 0, 1, 2, 2, 2}
+	c := []uint64{0, 2, 2, 0, 1, 2}
+	d := []float64{1, 2, 3, 4, 5, 6}
 
 	N := len(r)
 
@@ -115,17 +115,17 @@ func TestCSRBinaryGetRowMethod(t *testing.T) {
 	two := csr.GetRow(1)
 	three := csr.GetRow(2)
 
-	if !reflect.DeepEqual(one, map[uint32]float32{0: 1, 2: 1}) {
+	if !reflect.DeepEqual(one, Sample{{0, 1}, {2, 1}}) {
 		t.Error("Wrong GetRow(0)")
 		fmt.Println(one)
 	}
 
-	if !reflect.DeepEqual(two, map[uint32]float32{2: 1}) {
+	if !reflect.DeepEqual(two, Sample{{2, 1}}) {
 		t.Error("Wrong GetRow(1)")
 		fmt.Println(two)
 	}
 
-	if !reflect.DeepEqual(three, map[uint32]float32{0: 1, 1: 1, 2: 1}) {
+	if !reflect.DeepEqual(three, Sample{{0, 1}, {1, 1}, {2, 1}}) {
 		t.Error("Wrong GetRow(2)")
 		fmt.Println(three)
 	}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// MapBinary makes dataset backed by memory mapped file
+// written by SaveBinary. Arrays are not copied to heap,
+// so processes using the same file share page cache.
+// Dataset is read only and must be closed by Close.
+// Use View to access rows without allocations
+func (d *Dataset) MapBinary(path string) error {
+	if !isLittleEndian {
+		return d.LoadBinary(path)
+	}
+
+	data, err := mapFile(path)
+	if err != nil {
+		return err
+	}
+	if err := d.fromMapping(data); err != nil {
+		unmapFile(data)
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Close releases memory mapping of dataset
+// created by MapBinary. Views of dataset, e.g. made
+// by Shuffle, do not own mapping and must not be
+// used after it is released
+func (d *Dataset) Close() error {
+	if d.data == nil || d.data.mapping == nil || d.rows != nil {
+		return nil
+	}
+	mapping := d.data.mapping
+	d.data = nil
+	d.labels = nil
+	d.sampleWeights = nil
+	return unmapFile(mapping)
+}
+
+func (d *Dataset) fromMapping(data []byte) error {
+	var header datasetHeader
+	if len(data) < datasetHeaderSize {
+		return ErrBadDataset
+	}
+	err := binary.Read(bytes.NewReader(data[:datasetHeaderSize]), binary.LittleEndian, &header)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadDataset, err)
+	}
+	if err := header.validate(); err != nil {
+		return err
+	}
+	// check size of file before taking sections
+	if err := header.checkSize(uint64(len(data))); err != nil {
+		return err
+	}
+
+	isBinary := header.Flags&binaryFlag != 0
+	isWeighted := header.Flags&weightedFlag != 0
+	indexWidth, valueWidth := header.widths()
+
+	offset := uint64(datasetHeaderSize)
+	section := func(n, width uint64) unsafe.Pointer {
+		ptr := unsafe.Pointer(&data[offset])
+		offset += sectionSize(n, width)
+		return ptr
+	}
+
+	csr := MakeCSR(isBinary)
+	csr.mapping = data
+	csr.ia = unsafe.Slice((*uint64)(section(header.NRows+1, 8)), header.NRows+1)
+	if header.Nnz > 0 {
+		if indexWidth == 4 {
+			csr.ja32 = unsafe.Slice((*uint32)(section(header.Nnz, 4)), header.Nnz)
+		} else {
+			csr.ja = unsafe.Slice((*uint64)(section(header.Nnz, 8)), header.Nnz)
+		}
+		if !isBinary && valueWidth == 4 {
+			csr.dat32 = unsafe.Slice((*float32)(section(header.Nnz, 4)), header.Nnz)
+		} else if !isBinary {
+			csr.dat = unsafe.Slice((*float64)(section(header.Nnz, 8)), header.Nnz)
+		}
+	}
+	if err := validateRowIndex(csr.ia, header.Nnz); err != nil {
+		return err
+	}
+	if err := validateColumns(csr, header.NCols); err != nil {
+		return err
+	}
+	csr.setShape(header.NRows, header.NCols, header.Nnz)
+
+	var labels, weights []float64
+	if header.NRows > 0 {
+		labels = unsafe.Slice((*float64)(section(header.NRows, 8)), header.NRows)
+		if isWeighted {
+			weights = unsafe.Slice((*float64)(section(header.NRows, 8)), header.NRows)
+		}
+	}
+
+	var names []string
+	if header.NamesSize > 0 {
+		names = strings.Split(string(data[offset:]), "\n")
+	}
+
+	d.data = csr
+	d.labels = labels
+	d.isWeighted = isWeighted
+	d.sampleWeights = weights
+	d.weightsSum = header.WeightsSum
+	d.meanTarget = header.MeanTarget
+	d.featureNames = names
+	return nil
+}
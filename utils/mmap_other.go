@@ -0,0 +1,29 @@
+//go:build !unix
+
+package utils
+
+import (
+	"os"
+	"unsafe"
+)
+
+// mapFile reads whole file where mmap is not available
+func mapFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrBadDataset
+	}
+
+	// sections must be 8 byte aligned
+	aligned := make([]uint64, (len(data)+7)/8)
+	buf := rawBytes(unsafe.Pointer(&aligned[0]), len(aligned), 8)[:len(data)]
+	copy(buf, data)
+	return buf, nil
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
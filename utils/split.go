@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Fold is a pair of training and validation
+// parts of dataset made by KFold
+type Fold struct {
+	Train *Dataset
+	Valid *Dataset
+}
+
+// Subset returns view of given rows of dataset. View
+// shares matrix with d, sample weights and feature
+// names are carried over
+func (d *Dataset) Subset(rows []uint64) *Dataset {
+	return d.subset(append([]uint64{}, rows...))
+}
+
+// SplitRandom splits rows of dataset at random into
+// parts of given fractions plus the last part of the
+// rest, e.g. SplitRandom(seed, 0.8, 0.1) gives train,
+// valid and test. Parts are views keeping file order.
+// Fractions must be nonnegative and sum to at most 1
+func (d *Dataset) SplitRandom(seed int64, fractions ...float64) ([]*Dataset, error) {
+	if err := checkFractions(fractions); err != nil {
+		return nil, err
+	}
+	rows := d.permutation(seed)
+	return d.views(partition(rows, fractions, nil)), nil
+}
+
+// SplitStratified is SplitRandom keeping share of
+// every label value the same in all parts
+func (d *Dataset) SplitStratified(seed int64, fractions ...float64) ([]*Dataset, error) {
+	if err := checkFractions(fractions); err != nil {
+		return nil, err
+	}
+	var parts [][]uint64
+	for _, group := range d.strata(seed) {
+		parts = partition(group, fractions, parts)
+	}
+	return d.views(parts), nil
+}
+
+// SplitOrdered splits dataset into consecutive parts
+// of given fractions plus the last part of the rest.
+// For files sorted by time later parts are later in
+// time, so validation does not look into the past
+func (d *Dataset) SplitOrdered(fractions ...float64) ([]*Dataset, error) {
+	if err := checkFractions(fractions); err != nil {
+		return nil, err
+	}
+	rows := make([]uint64, d.NRows())
+	for i := range rows {
+		rows[i] = uint64(i)
+	}
+	return d.views(partition(rows, fractions, nil)), nil
+}
+
+// KFold splits dataset into k folds at random. Each of
+// them is validation part once, while the rest are
+// training part. Stratified folds keep share of every
+// label value. Number of folds must be in [2, NRows]
+func (d *Dataset) KFold(k int, seed int64, stratified bool) ([]Fold, error) {
+	if k < 2 || uint64(k) > d.NRows() {
+		return nil, fmt.Errorf("utils: can not make %d folds of %d rows", k, d.NRows())
+	}
+
+	groups := [][]uint64{d.permutation(seed)}
+	if stratified {
+		groups = d.strata(seed)
+	}
+
+	// rows are dealt to folds one by one, continuing
+	// from group to group, so folds differ by one row
+	fold := make([]int, d.NRows())
+	next := 0
+	for _, group := range groups {
+		for _, row := range group {
+			fold[row] = next
+			next = (next + 1) % k
+		}
+	}
+
+	folds := make([]Fold, k)
+	for j := range folds {
+		var train, valid []uint64
+		for row, f := range fold {
+			if f == j {
+				valid = append(valid, uint64(row))
+			} else {
+				train = append(train, uint64(row))
+			}
+		}
+		folds[j] = Fold{Train: d.subset(train), Valid: d.subset(valid)}
+	}
+	return folds, nil
+}
+
+// permutation returns rows of dataset in random order
+func (d *Dataset) permutation(seed int64) []uint64 {
+	rows := make([]uint64, d.NRows())
+	for i := range rows {
+		rows[i] = uint64(i)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(rows), func(i, j int) {
+		rows[i], rows[j] = rows[j], rows[i]
+	})
+	return rows
+}
+
+// strata returns rows of every label value in random
+// order. Groups are sorted by label value
+func (d *Dataset) strata(seed int64) [][]uint64 {
+	byLabel := make(map[float64][]uint64)
+	for _, row := range d.permutation(seed) {
+		label := d.Label(row)
+		byLabel[label] = append(byLabel[label], row)
+	}
+
+	labels := make([]float64, 0, len(byLabel))
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	sort.Float64s(labels)
+
+	groups := make([][]uint64, len(labels))
+	for i, label := range labels {
+		groups[i] = byLabel[label]
+	}
+	return groups
+}
+
+// views returns views of parts with rows sorted
+func (d *Dataset) views(parts [][]uint64) []*Dataset {
+	result := make([]*Dataset, len(parts))
+	for i, rows := range parts {
+		sort.Slice(rows, func(a, b int) bool { return rows[a] < rows[b] })
+		result[i] = d.subset(rows)
+	}
+	return result
+}
+
+// partition appends rows to len(fractions)+1 parts,
+// part i gets fractions[i] of rows, the last one gets
+// the rest. Parts are allocated if nil
+func partition(rows []uint64, fractions []float64, parts [][]uint64) [][]uint64 {
+	if parts == nil {
+		parts = make([][]uint64, len(fractions)+1)
+	}
+
+	n := float64(len(rows))
+	var start int
+	var cumsum float64
+	for i, fraction := range fractions {
+		cumsum += fraction
+		end := int(math.Round(cumsum * n))
+		if end > len(rows) {
+			end = len(rows)
+		}
+		parts[i] = append(parts[i], rows[start:end]...)
+		start = end
+	}
+	last := len(fractions)
+	parts[last] = append(parts[last], rows[start:]...)
+	return parts
+}
+
+func checkFractions(fractions []float64) error {
+	var sum float64
+	for _, fraction := range fractions {
+		if fraction < 0 || math.IsNaN(fraction) {
+			return fmt.Errorf("utils: bad split fraction %v", fraction)
+		}
+		sum += fraction
+	}
+	if sum > 1+1e-9 {
+		return fmt.Errorf("utils: split fractions sum to %v", sum)
+	}
+	return nil
+}
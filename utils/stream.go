@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const maxLineSize = 64 * 1024 * 1024
+
+// SVMScanner reads samples in libsvm format one by
+// one, so memory usage does not depend on input size.
+// Usage is similar to bufio.Scanner:
+//
+//	s := MakeSVMScanner(r, true)
+//	for s.Scan() {
+//		x, y := s.Sample(), s.Label()
+//	}
+//	if err := s.Err(); err != nil {...}
+type SVMScanner struct {
+	scanner  *bufio.Scanner
+	isBinary bool
+
+	sample Sample
+	label  float64
+	line   uint64
+	err    error
+}
+
+// MakeSVMScanner creates scanner reading from r
+func MakeSVMScanner(r io.Reader, isBinary bool) *SVMScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &SVMScanner{scanner: scanner, isBinary: isBinary}
+}
+
+// Scan advances to the next sample. Returns false
+// at the end of input or on error
+func (s *SVMScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	for s.scanner.Scan() {
+		s.line++
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := s.parse(line); err != nil {
+			s.err = fmt.Errorf("line %d: %w", s.line, err)
+			return false
+		}
+		return true
+	}
+
+	s.err = s.scanner.Err()
+	return false
+}
+
+func (s *SVMScanner) parse(line []byte) error {
+	label, sample, err := parseSVMLine(line, s.isBinary, s.sample[:0])
+	s.sample = sample
+	if err != nil {
+		return err
+	}
+	s.label = label
+	return nil
+}
+
+// parseSVMLine parses label and features of line in
+// libsvm format, features are appended to buf.
+// Zero values are dropped, as sparse rows omit them
+func parseSVMLine(line []byte, isBinary bool, buf Sample) (float64, Sample, error) {
+	token, line := nextToken(line)
+	label, err := strconv.ParseFloat(string(token), 64)
+	if err != nil {
+		return 0, buf, err
+	}
+
+	for len(line) > 0 {
+		token, line = nextToken(line)
+		if len(token) == 0 {
+			continue
+		}
+
+		sep := bytes.IndexByte(token, ':')
+		key := token
+		if sep >= 0 {
+			key = token[:sep]
+		}
+		colIdx, err := strconv.ParseUint(string(key), 10, 64)
+		if err != nil {
+			return 0, buf, err
+		}
+
+		if sep < 0 && !isBinary {
+			return 0, buf, fmt.Errorf("no value in token %q", token)
+		}
+		// value is checked in binary mode too, though
+		// every present feature is read as 1 there
+		val := 1.0
+		if sep >= 0 {
+			parsed, err := strconv.ParseFloat(string(token[sep+1:]), 64)
+			if err != nil {
+				return 0, buf, err
+			}
+			if !isBinary {
+				val = parsed
+			}
+		}
+		if val == 0 {
+			continue
+		}
+		buf = append(buf, Feature{colIdx, val})
+	}
+
+	return label, buf, nil
+}
+
+// nextToken splits space separated token
+// off the beginning of line
+func nextToken(line []byte) ([]byte, []byte) {
+	line = bytes.TrimLeft(line, " \t")
+	end := bytes.IndexAny(line, " \t")
+	if end < 0 {
+		return line, nil
+	}
+	return line[:end], line[end+1:]
+}
+
+// Sample returns features of current sample. Slice
+// is reused, so it is valid until next call of Scan
+func (s *SVMScanner) Sample() Sample {
+	return s.sample
+}
+
+// Label returns target of current sample
+func (s *SVMScanner) Label() float64 {
+	return s.label
+}
+
+// Line returns number of current line, starting from 1
+func (s *SVMScanner) Line() uint64 {
+	return s.line
+}
+
+// Err returns first error met by scanner
+func (s *SVMScanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Vectorizer turns raw string tokens into features
+// with hashing trick, so no vocabulary is needed.
+// Token "field=value" becomes feature with value 1,
+// token "name:0.5" becomes feature "name" with value
+// 0.5. Line in Vowpal Wabbit style
+//
+//	|user age:25 gender=m |ad id=42
+//
+// prefixes every token with its namespace: "user^age".
+// Not safe for concurrent use if reverse dictionary
+// is kept
+type Vectorizer struct {
+	bits uint
+	mask uint64
+	seed uint32
+	wide bool
+
+	dict map[uint64][]string
+}
+
+// MakeVectorizer creates vectorizer hashing tokens into
+// 2^nbits columns. With wide set 64 bit hash is used,
+// otherwise 32 bit MurmurHash3. With keepDict set every
+// hashed token is remembered for Lookup
+func MakeVectorizer(nbits uint, seed uint32, wide bool, keepDict bool) *Vectorizer {
+	v := &Vectorizer{
+		bits: nbits,
+		mask: 1<<nbits - 1,
+		seed: seed,
+		wide: wide,
+	}
+	if nbits >= 64 {
+		v.mask = ^uint64(0)
+	}
+	if keepDict {
+		v.dict = make(map[uint64][]string)
+	}
+	return v
+}
+
+// NCols returns size of hashed feature space
+func (v *Vectorizer) NCols() uint64 {
+	if v.bits >= 64 {
+		return ^uint64(0)
+	}
+	return 1 << v.bits
+}
+
+// Hash returns column index of token
+func (v *Vectorizer) Hash(token string) uint64 {
+	var h uint64
+	if v.wide {
+		h = Hash64(token, uint64(v.seed))
+	} else {
+		h = uint64(Murmur3(token, v.seed))
+	}
+	h &= v.mask
+
+	if v.dict != nil {
+		known := false
+		for _, t := range v.dict[h] {
+			known = known || t == token
+		}
+		if !known {
+			v.dict[h] = append(v.dict[h], token)
+		}
+	}
+	return h
+}
+
+// Lookup returns tokens hashed into column key,
+// more than one in case of collision. Returns
+// nil if reverse dictionary is not kept
+func (v *Vectorizer) Lookup(key uint64) []string {
+	return v.dict[key]
+}
+
+// Transform hashes tokens without namespace
+// and appends them to buf
+func (v *Vectorizer) Transform(tokens []string, buf Sample) Sample {
+	for _, token := range tokens {
+		buf = v.appendToken(buf, "", token, 1.0)
+	}
+	return buf
+}
+
+// TransformLine hashes features of line with Vowpal
+// Wabbit style namespaces and appends them to buf.
+// Namespace may be scaled like "|user:0.5 ..."
+func (v *Vectorizer) TransformLine(line string, buf Sample) Sample {
+	for i, section := range strings.Split(line, "|") {
+		fields := strings.Fields(section)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// namespace is attached directly to the bar
+		namespace, scale := "", 1.0
+		if i > 0 && !strings.HasPrefix(section, " ") && !strings.HasPrefix(section, "\t") {
+			namespace, scale = splitValue(fields[0])
+			fields = fields[1:]
+		}
+
+		for _, token := range fields {
+			buf = v.appendToken(buf, namespace, token, scale)
+		}
+	}
+	return buf
+}
+
+func (v *Vectorizer) appendToken(buf Sample, namespace, token string, scale float64) Sample {
+	name, value := splitValue(token)
+	if name == "" || value == 0 {
+		return buf
+	}
+	if namespace != "" {
+		name = namespace + "^" + name
+	}
+	return append(buf, Feature{v.Hash(name), value * scale})
+}
+
+// splitValue splits "name:value" token. Value
+// is 1 if it is missing or malformed
+func splitValue(token string) (string, float64) {
+	sep := strings.LastIndexByte(token, ':')
+	if sep < 0 {
+		return token, 1.0
+	}
+	value, err := strconv.ParseFloat(token[sep+1:], 64)
+	if err != nil {
+		return token, 1.0
+	}
+	return token[:sep], value
+}
+
+// Murmur3 is 32 bit MurmurHash3 (x86_32) of s
+func Murmur3(s string, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	data := []byte(s)
+	nblocks := len(data) / 4
+	for i := 0; i < nblocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[nblocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// Hash64 is seeded 64 bit FNV-1a of s
+// followed by splitmix64 finalizer
+func Hash64(s string, seed uint64) uint64 {
+	const prime = 1099511628211
+	h := uint64(14695981039346656037) ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime
+	}
+
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}